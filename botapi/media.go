@@ -0,0 +1,242 @@
+package botapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/pkg/errors"
+)
+
+// URL is the Bot API URL used by the client library.
+// Requests are routed elsewhere with RewriteTransport.
+const URL = "https://api.telegram.org"
+
+// Attributes are video attributes not supported by the Bot API client library.
+type Attributes struct {
+	Width     int
+	Height    int
+	Duration  int
+	Thumbnail []byte
+}
+
+// OutgoingMedia is a media message sent by MediaSender.
+type OutgoingMedia struct {
+	// Type is the Bot API media type: photo, video, animation, audio or document.
+	Type string
+	// URL is either a remote media URL or a file_id. Input is uploaded if URL is empty.
+	URL         string
+	Input       flu.Input
+	MIMEType    string
+	Caption     string
+	ParseMode   string
+	ReplyMarkup interface{}
+	Attributes
+}
+
+// MediaSender sends media with Bot API directly, so that video attributes are passed along.
+// All the other requests are made by the client library.
+type MediaSender struct {
+	// Client should be the HTTP client used by the client library.
+	Client *http.Client
+	Token  string
+}
+
+// Send sends the media to the chat and returns file_id of the sent media.
+// Flood control errors are retried.
+func (s *MediaSender) Send(ctx context.Context, chatID int64, media OutgoingMedia) (string, error) {
+	for {
+		fileID, retryAfter, err := s.send(ctx, chatID, media)
+		if retryAfter <= 0 {
+			return fileID, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+func (s *MediaSender) send(ctx context.Context, chatID int64, media OutgoingMedia) (string, time.Duration, error) {
+	method := "send" + strings.Title(media.Type)
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	// uploads are streamed instead of being buffered
+	go func() { _ = writer.CloseWithError(media.write(form, chatID)) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL+"/bot"+s.Token+"/"+method, reader)
+	if err != nil {
+		_ = reader.Close()
+		return "", 0, errors.Wrap(err, "create request")
+	}
+
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, errors.Wrap(err, method)
+	}
+
+	defer flu.Close(resp.Body)
+	var response struct {
+		OK          bool   `json:"ok"`
+		ErrorCode   int    `json:"error_code"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+		Result messageFiles `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", 0, errors.Wrapf(err, "decode %s response", method)
+	}
+
+	if !response.OK {
+		err := errors.Errorf("%s: %d %s", method, response.ErrorCode, response.Description)
+		if response.ErrorCode == http.StatusTooManyRequests {
+			return "", time.Duration(response.Parameters.RetryAfter) * time.Second, err
+		}
+
+		return "", 0, err
+	}
+
+	return response.Result.fileID(), 0, nil
+}
+
+type fileInfo struct {
+	FileID string `json:"file_id"`
+}
+
+type messageFiles struct {
+	Photo     []fileInfo `json:"photo"`
+	Video     *fileInfo  `json:"video"`
+	Animation *fileInfo  `json:"animation"`
+	Document  *fileInfo  `json:"document"`
+	Audio     *fileInfo  `json:"audio"`
+}
+
+func (m messageFiles) fileID() string {
+	for _, file := range []*fileInfo{m.Video, m.Animation, m.Document, m.Audio} {
+		if file != nil {
+			return file.FileID
+		}
+	}
+
+	if len(m.Photo) > 0 {
+		// the largest size goes last
+		return m.Photo[len(m.Photo)-1].FileID
+	}
+
+	return ""
+}
+
+// write writes request fields and files to the form and closes it.
+func (m OutgoingMedia) write(form *multipart.Writer, chatID int64) error {
+	fields := [][2]string{
+		{"chat_id", strconv.FormatInt(chatID, 10)},
+		{"caption", m.Caption},
+		{"parse_mode", m.ParseMode},
+	}
+
+	if m.ReplyMarkup != nil {
+		markup, err := json.Marshal(m.ReplyMarkup)
+		if err != nil {
+			return errors.Wrap(err, "encode reply markup")
+		}
+
+		fields = append(fields, [2]string{"reply_markup", string(markup)})
+	}
+
+	if m.Type == "video" || m.Type == "animation" {
+		setAttributes(m.Type == "video", m.Attributes, func(key string, value interface{}) {
+			fields = append(fields, [2]string{key, fmt.Sprint(value)})
+		})
+	}
+
+	if m.URL != "" {
+		fields = append(fields, [2]string{m.Type, m.URL})
+	}
+
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return errors.Wrapf(err, "write %s", field[0])
+		}
+	}
+
+	if m.URL == "" {
+		filename := m.Type
+		if i := strings.LastIndex(m.MIMEType, "/"); i >= 0 {
+			filename += "." + m.MIMEType[i+1:]
+		}
+
+		if err := writeFile(form, m.Type, filename, m.MIMEType, m.Input); err != nil {
+			return err
+		}
+	}
+
+	if m.Thumbnail != nil && (m.Type == "video" || m.Type == "animation") {
+		if err := writeFile(form, "thumb", "thumb.jpg", "image/jpeg", flu.Bytes(m.Thumbnail)); err != nil {
+			return err
+		}
+	}
+
+	return form.Close()
+}
+
+func writeFile(form *multipart.Writer, name, filename, mimeType string, input flu.Input) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, name, filename))
+	if mimeType != "" {
+		header.Set("Content-Type", mimeType)
+	}
+
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return errors.Wrapf(err, "create %s", name)
+	}
+
+	reader, err := input.Reader()
+	if err != nil {
+		return errors.Wrapf(err, "read %s", name)
+	}
+
+	defer flu.Close(reader)
+	if _, err := io.Copy(part, reader); err != nil {
+		return errors.Wrapf(err, "write %s", name)
+	}
+
+	return nil
+}
+
+func setAttributes(video bool, attrs Attributes, set func(key string, value interface{})) {
+	if attrs.Width > 0 && attrs.Height > 0 {
+		set("width", attrs.Width)
+		set("height", attrs.Height)
+	}
+
+	if attrs.Duration > 0 {
+		set("duration", attrs.Duration)
+	}
+
+	if video {
+		set("supports_streaming", true)
+	}
+}
@@ -0,0 +1,66 @@
+package botapi_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w/hikkabot/botapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaSender(t *testing.T) {
+	server := botapi.NewServer()
+	defer server.Close()
+
+	sender := &botapi.MediaSender{Client: server.Client().Client, Token: "test"}
+	ctx := context.Background()
+	video := []byte("video contents")
+	fileID, err := sender.Send(ctx, 1, botapi.OutgoingMedia{
+		Type:      "video",
+		Input:     flu.Bytes(video),
+		MIMEType:  "video/mp4",
+		Caption:   "<b>caption</b>",
+		ParseMode: "HTML",
+		Attributes: botapi.Attributes{
+			Width:     1280,
+			Height:    720,
+			Duration:  15,
+			Thumbnail: []byte("thumb"),
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "file1", fileID)
+
+	fileID, err = sender.Send(ctx, 1, botapi.OutgoingMedia{
+		Type:       "animation",
+		URL:        "https://example.com/a.mp4",
+		Attributes: botapi.Attributes{Duration: 7},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "file2", fileID)
+
+	requests := server.Requests()
+	assert.Len(t, requests, 2)
+	request := requests[0]
+	assert.Equal(t, "sendVideo", request.Method)
+	assert.Equal(t, int64(1), request.ChatID())
+	assert.Equal(t, "<b>caption</b>", request.Text())
+	assert.Equal(t, "HTML", request.Params["parse_mode"])
+	assert.Equal(t, "1280", request.Params["width"])
+	assert.Equal(t, "720", request.Params["height"])
+	assert.Equal(t, "15", request.Params["duration"])
+	assert.Equal(t, "true", request.Params["supports_streaming"])
+	assert.Equal(t, video, request.Files["video"].Data)
+	assert.Equal(t, "video/mp4", request.Files["video"].MIMEType)
+	assert.Equal(t, []byte("thumb"), request.Files["thumb"].Data)
+
+	request = requests[1]
+	assert.Equal(t, "sendAnimation", request.Method)
+	assert.Equal(t, "", request.Params["width"])
+	assert.Equal(t, "7", request.Params["duration"])
+	assert.Equal(t, "", request.Params["supports_streaming"])
+	assert.Equal(t, "https://example.com/a.mp4", request.Params["animation"])
+}
@@ -0,0 +1,227 @@
+package botapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Media is a single media attachment extracted from a Bot API request.
+// Either URL or Data is set depending on whether the media was passed
+// by reference (URL or file_id) or uploaded.
+type Media struct {
+	Type     string `json:"type"`
+	URL      string `json:"url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+var mediaMethods = map[string]string{
+	"sendPhoto":     "photo",
+	"sendVideo":     "video",
+	"sendAnimation": "animation",
+	"sendDocument":  "document",
+	"sendAudio":     "audio",
+}
+
+// Request is a decoded Bot API request.
+type Request struct {
+	Method string
+	Params map[string]string
+	Files  map[string]Media
+}
+
+func ParseRequest(r *http.Request) (*Request, error) {
+	request := &Request{
+		Method: path.Base(r.URL.Path),
+		Params: make(map[string]string),
+		Files:  make(map[string]Media),
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, errors.Wrap(err, "parse multipart form")
+		}
+
+		for name, headers := range r.MultipartForm.File {
+			if len(headers) == 0 {
+				continue
+			}
+
+			header := headers[0]
+			file, err := header.Open()
+			if err != nil {
+				return nil, errors.Wrapf(err, "open %s", name)
+			}
+
+			data, err := ioutil.ReadAll(file)
+			_ = file.Close()
+			if err != nil {
+				return nil, errors.Wrapf(err, "read %s", name)
+			}
+
+			mimeType := header.Header.Get("Content-Type")
+			if mimeType == "" || mimeType == "application/octet-stream" {
+				if byExt := mime.TypeByExtension(filepath.Ext(header.Filename)); byExt != "" {
+					mimeType = byExt
+				}
+			}
+
+			request.Files[name] = Media{
+				Filename: header.Filename,
+				MIMEType: mimeType,
+				Data:     data,
+			}
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return nil, errors.Wrap(err, "parse form")
+	}
+
+	for key, values := range r.Form {
+		if len(values) > 0 {
+			request.Params[key] = values[0]
+		}
+	}
+
+	return request, nil
+}
+
+func (r *Request) ChatID() int64 {
+	id, _ := strconv.ParseInt(r.Params["chat_id"], 10, 64)
+	return id
+}
+
+// IsSend checks if the request sends a message or media.
+func (r *Request) IsSend() bool {
+	_, ok := mediaMethods[r.Method]
+	return ok || r.Method == "sendMessage" || r.Method == "sendMediaGroup"
+}
+
+// Text returns the message text or media caption(s).
+func (r *Request) Text() string {
+	switch r.Method {
+	case "sendMessage":
+		return r.Params["text"]
+	case "sendMediaGroup":
+		captions := make([]string, 0)
+		for _, item := range r.mediaGroup() {
+			if item.Caption != "" {
+				captions = append(captions, item.Caption)
+			}
+		}
+
+		return strings.Join(captions, "\n")
+	default:
+		return r.Params["caption"]
+	}
+}
+
+// Media returns media sent with the request.
+func (r *Request) Media() []Media {
+	media := make([]Media, 0)
+	if r.Method == "sendMediaGroup" {
+		for _, item := range r.mediaGroup() {
+			if m, ok := r.media(item.Type, item.Media, ""); ok {
+				media = append(media, m)
+			}
+		}
+	} else if field, ok := mediaMethods[r.Method]; ok {
+		if m, ok := r.media(field, r.Params[field], field); ok {
+			media = append(media, m)
+		}
+	}
+
+	return media
+}
+
+type inputMedia struct {
+	Type    string `json:"type"`
+	Media   string `json:"media"`
+	Caption string `json:"caption"`
+}
+
+func (r *Request) mediaGroup() []inputMedia {
+	items := make([]inputMedia, 0)
+	_ = json.Unmarshal([]byte(r.Params["media"]), &items)
+	return items
+}
+
+func (r *Request) media(mediaType, value, field string) (Media, bool) {
+	var media Media
+	switch {
+	case strings.HasPrefix(value, "attach://"):
+		file, ok := r.Files[value[9:]]
+		if !ok {
+			return Media{}, false
+		}
+
+		media = file
+	case value != "":
+		media = Media{URL: value}
+	default:
+		file, ok := r.Files[field]
+		if !ok {
+			return Media{}, false
+		}
+
+		media = file
+	}
+
+	media.Type = mediaType
+	return media, true
+}
+
+func (r *Request) MessageResult(messageID int64) map[string]interface{} {
+	return map[string]interface{}{
+		"message_id": messageID,
+		"date":       time.Now().Unix(),
+		"chat": map[string]interface{}{
+			"id":   r.ChatID(),
+			"type": "private",
+		},
+	}
+}
+
+func WriteResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":     true,
+		"result": result,
+	})
+}
+
+func WriteError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":          false,
+		"error_code":  code,
+		"description": description,
+	})
+}
+
+// HandlerTransport is a http.RoundTripper serving requests in-process with the wrapped http.Handler.
+type HandlerTransport struct {
+	http.Handler
+}
+
+func (t HandlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.ServeHTTP(recorder, req)
+	if req.Body != nil {
+		// http.RoundTripper must always close the request body
+		_ = req.Body.Close()
+	}
+
+	return recorder.Result(), nil
+}
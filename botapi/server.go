@@ -0,0 +1,347 @@
+package botapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/pkg/errors"
+)
+
+// Username is the bot username reported by Server.
+const Username = "hikkabot_test_bot"
+
+// Server is a local stand-in for Telegram Bot API.
+// It records all send requests, serves queued updates via getUpdates
+// and can simulate flood control errors.
+type Server struct {
+	*httptest.Server
+
+	// OnSend is called for every recorded send request. Optional.
+	OnSend func(request *Request)
+
+	requests  []*Request
+	updates   []map[string]interface{}
+	flood     map[string]floodError
+	messageID int64
+	updateID  int64
+	mu        sync.Mutex
+	updated   chan struct{}
+}
+
+type floodError struct {
+	times      int
+	retryAfter int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		flood:   make(map[string]floodError),
+		updated: make(chan struct{}, 1),
+	}
+
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Client returns a client which routes all requests to this server.
+func (s *Server) Client() *fluhttp.Client {
+	client, err := NewClient(s.URL)
+	if err != nil {
+		panic(err)
+	}
+
+	return client
+}
+
+// Requests returns recorded send requests filtered by method.
+// All send requests are returned if no methods are passed.
+func (s *Server) Requests(methods ...string) []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]*Request, 0)
+	for _, request := range s.requests {
+		if len(methods) == 0 {
+			requests = append(requests, request)
+			continue
+		}
+
+		for _, method := range methods {
+			if request.Method == method {
+				requests = append(requests, request)
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
+// Await waits until at least count send requests are recorded.
+func (s *Server) Await(ctx context.Context, count int) ([]*Request, error) {
+	for {
+		if requests := s.Requests(); len(requests) >= count {
+			return requests, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// Flood makes the next times calls of the method fail with 429 Too Many Requests.
+func (s *Server) Flood(method string, times int, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flood[method] = floodError{times, retryAfter}
+}
+
+// Command enqueues a text message update from userID in chatID.
+func (s *Server) Command(chatID, userID int64, text string) {
+	length := len([]rune(text))
+	for i, c := range []rune(text) {
+		if c == ' ' {
+			length = i
+			break
+		}
+	}
+
+	s.enqueue("message", s.message(chatID, userID, text, map[string]interface{}{
+		"entities": []map[string]interface{}{{
+			"type":   "bot_command",
+			"offset": 0,
+			"length": length,
+		}},
+	}))
+}
+
+// CallbackQuery enqueues a callback query update (as if an inline button was pressed).
+func (s *Server) CallbackQuery(chatID, userID int64, data string) {
+	s.mu.Lock()
+	id := s.updateID + 1
+	s.mu.Unlock()
+	s.enqueue("callback_query", map[string]interface{}{
+		"id":            strconv.FormatInt(id, 10),
+		"from":          user(userID),
+		"message":       s.message(chatID, 0, "", nil),
+		"chat_instance": strconv.FormatInt(chatID, 10),
+		"data":          data,
+	})
+}
+
+func (s *Server) message(chatID, userID int64, text string, extra map[string]interface{}) map[string]interface{} {
+	s.mu.Lock()
+	s.messageID++
+	message := map[string]interface{}{
+		"message_id": s.messageID,
+		"date":       time.Now().Unix(),
+		"chat":       chat(chatID),
+	}
+	s.mu.Unlock()
+
+	if userID != 0 {
+		message["from"] = user(userID)
+	}
+
+	if text != "" {
+		message["text"] = text
+	}
+
+	for key, value := range extra {
+		message[key] = value
+	}
+
+	return message
+}
+
+func (s *Server) enqueue(key string, value interface{}) {
+	s.mu.Lock()
+	s.updateID++
+	s.updates = append(s.updates, map[string]interface{}{
+		"update_id": s.updateID,
+		key:         value,
+	})
+	s.mu.Unlock()
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := ParseRequest(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	if flood, ok := s.flood[request.Method]; ok && flood.times > 0 {
+		flood.times--
+		s.flood[request.Method] = flood
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = fmt.Fprintf(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after %d","parameters":{"retry_after":%d}}`,
+			flood.retryAfter, flood.retryAfter)
+		return
+	}
+
+	s.mu.Unlock()
+	switch {
+	case request.IsSend():
+		WriteResult(w, s.send(request))
+	case request.Method == "getUpdates":
+		WriteResult(w, s.getUpdates(r.Context(), request))
+	case request.Method == "getMe":
+		WriteResult(w, map[string]interface{}{
+			"id":         1,
+			"is_bot":     true,
+			"first_name": "hikkabot",
+			"username":   Username,
+		})
+	case request.Method == "getChat":
+		chatID := request.ChatID()
+		result := chat(chatID)
+		if chatID < 0 {
+			result["invite_link"] = fmt.Sprintf("https://t.me/joinchat/%d", -chatID)
+		}
+
+		WriteResult(w, result)
+	case request.Method == "exportChatInviteLink":
+		WriteResult(w, fmt.Sprintf("https://t.me/joinchat/%d", -request.ChatID()))
+	default:
+		WriteResult(w, true)
+	}
+}
+
+func (s *Server) send(request *Request) interface{} {
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	count := 1
+	if request.Method == "sendMediaGroup" {
+		count = len(request.Media())
+	}
+
+	results := make([]interface{}, count)
+	for i := range results {
+		s.messageID++
+		results[i] = request.MessageResult(s.messageID)
+	}
+
+	if field, ok := mediaMethods[request.Method]; ok {
+		// file_ids are reported for single media only
+		file := map[string]interface{}{"file_id": fmt.Sprintf("file%d", s.messageID)}
+		if field == "photo" {
+			results[0].(map[string]interface{})[field] = []interface{}{file}
+		} else {
+			results[0].(map[string]interface{})[field] = file
+		}
+	}
+
+	s.mu.Unlock()
+	if s.OnSend != nil {
+		s.OnSend(request)
+	}
+
+	if request.Method == "sendMediaGroup" {
+		return results
+	}
+
+	return results[0]
+}
+
+func (s *Server) getUpdates(ctx context.Context, request *Request) []map[string]interface{} {
+	offset, _ := strconv.ParseInt(request.Params["offset"], 10, 64)
+	timeout, _ := strconv.Atoi(request.Params["timeout"])
+	if timeout > 1 {
+		timeout = 1
+	}
+
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	for {
+		s.mu.Lock()
+		updates := make([]map[string]interface{}, 0)
+		for _, update := range s.updates {
+			if update["update_id"].(int64) >= offset {
+				updates = append(updates, update)
+			}
+		}
+
+		s.mu.Unlock()
+		if len(updates) > 0 || timeout == 0 {
+			return updates
+		}
+
+		select {
+		case <-s.updated:
+		case <-deadline:
+			return updates
+		case <-ctx.Done():
+			return updates
+		}
+	}
+}
+
+func chat(chatID int64) map[string]interface{} {
+	chatType := "private"
+	if chatID < 0 {
+		chatType = "channel"
+	}
+
+	return map[string]interface{}{
+		"id":    chatID,
+		"type":  chatType,
+		"title": strconv.FormatInt(chatID, 10),
+	}
+}
+
+func user(userID int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         userID,
+		"is_bot":     false,
+		"first_name": strconv.FormatInt(userID, 10),
+	}
+}
+
+// NewClient creates a client which routes all requests to the Bot API server at baseURL
+// instead of https://api.telegram.org.
+func NewClient(baseURL string) (*fluhttp.Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse base URL")
+	}
+
+	transport := &http.Transport{ResponseHeaderTimeout: 2 * time.Minute}
+	return fluhttp.NewClient(&http.Client{Transport: RewriteTransport{Base: base, Transport: transport}}), nil
+}
+
+// RewriteTransport rewrites request URL scheme and host to the ones of Base.
+type RewriteTransport struct {
+	Base      *url.URL
+	Transport http.RoundTripper
+}
+
+func (t RewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.Base.Scheme
+	req.URL.Host = t.Base.Host
+	req.URL.Path = strings.TrimRight(t.Base.Path, "/") + req.URL.Path
+	req.Host = t.Base.Host
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return transport.RoundTrip(req)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/jfk9w-go/flu/metrics"
 	telegram "github.com/jfk9w-go/telegram-bot-api"
 	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/botapi"
 	"github.com/pkg/errors"
 )
 
@@ -21,20 +22,42 @@ type HTMLWriterFactory interface {
 
 type TelegramHTML struct {
 	telegram.Sender
+	// Media sends media submitted to MediaManager along with video attributes. Optional.
+	Media *botapi.MediaSender
 }
 
 func (f TelegramHTML) CreateHTMLWriter(ctx context.Context, feedIDs ...ID) (*format.HTMLWriter, error) {
+	transport, err := f.CreateTransport(ctx, feedIDs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return format.HTMLWithTransport(format.WithParseMode(ctx, telegram.HTML), transport), nil
+}
+
+// CreateTransport creates a transport which records sent messages
+// into the delivery attached to the context by the aggregator.
+func (f TelegramHTML) CreateTransport(ctx context.Context, feedIDs ...ID) (format.Transport, error) {
 	chatIDs := make([]telegram.ChatID, len(feedIDs))
 	for i, feedID := range feedIDs {
 		chatIDs[i] = telegram.ID(feedID)
 	}
 
-	return format.HTML(ctx, telegram.Sender(f), false, chatIDs...), nil
+	return &telegramTransport{
+		TelegramTransport: &format.TelegramTransport{
+			Sender:  f.Sender,
+			ChatIDs: chatIDs,
+		},
+		media:    f.Media,
+		feedIDs:  feedIDs,
+		delivery: getDelivery(ctx),
+	}, nil
 }
 
 type aggregatorTask struct {
 	htmlWriterFactory HTMLWriterFactory
 	store             SubStorage
+	history           HistoryStorage
 	interval          time.Duration
 	vendors           map[string]Vendor
 	feedID            ID
@@ -87,19 +110,36 @@ func (t *aggregatorTask) update(ctx context.Context, sub Sub) error {
 	go vendor.LoadSub(vctx, sub.Data, queue)
 	count := 0
 	defer func() { log.Printf("[sub > %s] processed %d updates", sub.SubID, count) }()
+	prevData := sub.Data
 	for update := range queue.channel {
 		if update.Error != nil {
 			return errors.Wrap(update.Error, "update")
 		}
-		html, err := t.htmlWriterFactory.CreateHTMLWriter(ctx, t.feedID)
-		if err != nil {
-			return errors.Wrap(err, "create HTMLWriter")
+		if update.Write == nil {
+			data, err := DataFrom(update.Data)
+			if err != nil {
+				return errors.Wrap(err, "wrap data")
+			}
+			if err := t.updateStore(sub.SubID, data); err != nil {
+				return errors.Wrap(err, "store update")
+			}
+			prevData = data
+			continue
 		}
-		if err := update.Write(html); err != nil {
-			return errors.Wrap(err, "write")
+		delivery := make(Delivery, 0)
+		if err := writeUpdate(withDelivery(ctx, &delivery), t.htmlWriterFactory, t.feedID, update); err != nil {
+			return err
 		}
-		if err := html.Flush(); err != nil {
-			return errors.Wrap(err, "flush")
+		if update.Delivered != nil {
+			if err := update.Delivered(ctx); err != nil {
+				log.Printf("[sub > %s] failed to process delivered update: %s", sub.SubID, err)
+			}
+		}
+		// only delivered updates are replayed
+		if t.history != nil {
+			if err := t.history.SaveHistory(ctx, sub.SubID, delivery); err != nil {
+				log.Printf("[sub > %s] failed to save history: %s", sub.SubID, err)
+			}
 		}
 		data, err := DataFrom(update.Data)
 		if err != nil {
@@ -109,12 +149,13 @@ func (t *aggregatorTask) update(ctx context.Context, sub Sub) error {
 		if err != nil {
 			return errors.Wrap(err, "store update")
 		}
+		prevData = data
 		t.metrics.Counter("update_ok", sub.MetricsLabels()).Inc()
 		count++
 	}
 
 	if count == 0 {
-		err := t.updateStore(sub.SubID, sub.Data)
+		err := t.updateStore(sub.SubID, prevData)
 		if err != nil {
 			return errors.Wrap(err, "store update")
 		}
@@ -123,6 +164,20 @@ func (t *aggregatorTask) update(ctx context.Context, sub Sub) error {
 	return nil
 }
 
+func writeUpdate(ctx context.Context, htmlWriterFactory HTMLWriterFactory, feedID ID, update Update) error {
+	html, err := htmlWriterFactory.CreateHTMLWriter(ctx, feedID)
+	if err != nil {
+		return errors.Wrap(err, "create HTMLWriter")
+	}
+	if err := update.Write(html); err != nil {
+		return errors.Wrap(err, "write")
+	}
+	if err := html.Flush(); err != nil {
+		return errors.Wrap(err, "flush")
+	}
+	return nil
+}
+
 var updateStoreTimeout = 10 * time.Second
 
 func (t *aggregatorTask) updateStore(subID SubID, value interface{}) error {
@@ -134,6 +189,7 @@ func (t *aggregatorTask) updateStore(subID SubID, value interface{}) error {
 type Aggregator struct {
 	Executor          TaskExecutor
 	SubStorage        SubStorage
+	History           HistoryStorage
 	HTMLWriterFactory HTMLWriterFactory
 	Vendors           map[string]Vendor
 	UpdateInterval    time.Duration
@@ -172,6 +228,7 @@ func (a *Aggregator) submitTask(feedID ID) {
 	a.Executor.Submit(feedID, &aggregatorTask{
 		htmlWriterFactory: a.HTMLWriterFactory,
 		store:             a.SubStorage,
+		history:           a.History,
 		interval:          a.UpdateInterval,
 		vendors:           a.Vendors,
 		feedID:            feedID,
@@ -185,7 +242,7 @@ func (a *Aggregator) Close() error {
 	return a.SubStorage.Close()
 }
 
-func (a *Aggregator) Subscribe(ctx context.Context, feedID ID, ref string, options []string) (Sub, error) {
+func (a *Aggregator) parseSub(ctx context.Context, feedID ID, ref string, options []string) (Sub, error) {
 	for vendorID, vendor := range a.Vendors {
 		sub, err := vendor.ParseSub(ctx, ref, options)
 		switch err {
@@ -205,11 +262,6 @@ func (a *Aggregator) Subscribe(ctx context.Context, feedID ID, ref string, optio
 				return sub, errors.Wrap(err, "wrap data")
 			}
 
-			if err := a.SubStorage.CreateSub(ctx, sub); err != nil {
-				return sub, err
-			}
-
-			a.submitTask(feedID)
 			return sub, nil
 
 		case ErrWrongVendor:
@@ -223,6 +275,114 @@ func (a *Aggregator) Subscribe(ctx context.Context, feedID ID, ref string, optio
 	return Sub{}, ErrWrongVendor
 }
 
+func (a *Aggregator) Subscribe(ctx context.Context, feedID ID, ref string, options []string) (Sub, error) {
+	sub, err := a.parseSub(ctx, feedID, ref, options)
+	if err != nil {
+		return sub, err
+	}
+
+	if err := a.SubStorage.CreateSub(ctx, sub); err != nil {
+		return sub, err
+	}
+
+	a.submitTask(feedID)
+	return sub, nil
+}
+
+// Preview parses the subscription and writes at most limit updates from a single load pass to feedID.
+// Nothing is stored, so the subscription cursor is not advanced.
+// Vendors are loaded with a preview context (see IsPreview), so that they do not record anything either.
+func (a *Aggregator) Preview(ctx context.Context, feedID ID, ref string, options []string, limit int) (Sub, int, error) {
+	sub, err := a.parseSub(ctx, feedID, ref, options)
+	if err != nil {
+		return sub, 0, err
+	}
+
+	count, err := a.render(WithPreview(ctx), sub, limit)
+	return sub, count, err
+}
+
+type previewKey struct{}
+
+// WithPreview marks the context as used for rendering a preview.
+func WithPreview(ctx context.Context) context.Context {
+	return context.WithValue(ctx, previewKey{}, true)
+}
+
+// IsPreview checks if updates are loaded for a preview.
+// Vendors should not store anything and should not record media and texts as sent in this case.
+func IsPreview(ctx context.Context) bool {
+	preview, _ := ctx.Value(previewKey{}).(bool)
+	return preview
+}
+
+// Replay re-sends the last count deliveries of the subscription to feedID as they were recorded.
+// Vendors are not involved, so nothing is loaded, deduplicated or recorded again.
+// The amount of replayed deliveries is limited by the amount of stored ones.
+func (a *Aggregator) Replay(ctx context.Context, subID SubID, count int, feedID ID) (Sub, int, error) {
+	if a.History == nil {
+		return Sub{}, 0, errors.New("history is disabled")
+	}
+
+	factory, ok := a.HTMLWriterFactory.(TransportFactory)
+	if !ok {
+		return Sub{}, 0, errors.New("replay is not supported")
+	}
+
+	sub, err := a.SubStorage.GetSub(ctx, subID)
+	if err != nil {
+		return Sub{}, 0, errors.Wrap(err, "get")
+	}
+
+	deliveries, err := a.History.GetHistory(ctx, subID, count)
+	if err != nil {
+		return sub, 0, errors.Wrap(err, "get history")
+	}
+
+	transport, err := factory.CreateTransport(ctx, feedID)
+	if err != nil {
+		return sub, 0, errors.Wrap(err, "create transport")
+	}
+
+	for i, delivery := range deliveries {
+		if err := delivery.send(ctx, transport); err != nil {
+			return sub, i, err
+		}
+	}
+
+	return sub, len(deliveries), nil
+}
+
+func (a *Aggregator) render(ctx context.Context, sub Sub, limit int) (int, error) {
+	vendor, ok := a.Vendors[sub.Vendor]
+	if !ok {
+		return 0, errors.Errorf("invalid vendor: %s", sub.Vendor)
+	}
+
+	queue := NewQueue(sub.SubID, 1)
+	vctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go vendor.LoadSub(vctx, sub.Data, queue)
+	count := 0
+	for update := range queue.channel {
+		if update.Error != nil {
+			return count, errors.Wrap(update.Error, "update")
+		}
+		if update.Write == nil {
+			continue
+		}
+		if err := writeUpdate(ctx, a.HTMLWriterFactory, sub.FeedID, update); err != nil {
+			return count, err
+		}
+		count++
+		if count >= limit {
+			break
+		}
+	}
+
+	return count, nil
+}
+
 func (a *Aggregator) Suspend(ctx context.Context, subID SubID, err error) (Sub, error) {
 	if err := a.SubStorage.UpdateSub(ctx, subID, err); err != nil {
 		return Sub{}, err
@@ -0,0 +1,207 @@
+package feed_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/metrics"
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/botapi"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+type testVendor struct {
+	updates []string
+}
+
+func (v *testVendor) ParseSub(_ context.Context, ref string, _ []string) (feed.SubDraft, error) {
+	if !strings.HasPrefix(ref, "test/") {
+		return feed.SubDraft{}, feed.ErrWrongVendor
+	}
+
+	return feed.SubDraft{ID: ref, Name: ref, Data: 0}, nil
+}
+
+func (v *testVendor) LoadSub(ctx context.Context, rawData feed.Data, queue feed.Queue) {
+	defer queue.Close()
+	var offset int
+	if err := rawData.ReadTo(&offset); err != nil {
+		_ = queue.Submit(ctx, feed.Update{Error: err})
+		return
+	}
+
+	for i := offset; i < len(v.updates); i++ {
+		text := v.updates[i]
+		if err := queue.Submit(ctx, feed.Update{
+			Write: func(html *format.HTMLWriter) error {
+				html.Text(text)
+				return nil
+			},
+			Data: i + 1,
+		}); err != nil {
+			return
+		}
+	}
+}
+
+type testEnv struct {
+	server     *botapi.Server
+	bot        *telegram.Bot
+	store      *feed.SQLStorage
+	aggregator *feed.Aggregator
+	listener   *feed.CommandListener
+}
+
+func newTestEnv(t *testing.T, vendor feed.Vendor) *testEnv {
+	server := botapi.NewServer()
+	bot := telegram.NewBot(server.Client(), "test")
+	store, err := feed.NewSQLStorage(flu.DefaultClock, "sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	assert.Nil(t, err)
+	store.Registry = metrics.DummyRegistry{}
+	aggregator := (&feed.Aggregator{
+		Executor:          feed.NewTaskExecutor(),
+		SubStorage:        store,
+		History:           store,
+		HTMLWriterFactory: feed.TelegramHTML{Sender: bot},
+		UpdateInterval:    10 * time.Millisecond,
+	}).Vendor("test", vendor)
+
+	listener, err := (&feed.CommandListener{
+		Aggregator: aggregator,
+		Management: feed.NewSupervisorManagement(bot, 1),
+	}).Init(context.Background())
+	assert.Nil(t, err)
+	return &testEnv{server, bot, store, aggregator, listener}
+}
+
+func (e *testEnv) Close() {
+	_ = e.listener.Close()
+	e.server.Close()
+}
+
+func TestAggregator_Telegram(t *testing.T) {
+	env := newTestEnv(t, &testVendor{updates: []string{"first", "second"}})
+	defer env.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := env.listener.OnCommand(ctx, env.bot, telegram.Command{
+		Chat:    &telegram.Chat{ID: 1},
+		User:    &telegram.User{ID: 1},
+		Message: new(telegram.Message),
+		Key:     "/sub",
+		Args:    []string{"test/a", "-100"},
+	})
+	assert.Nil(t, err)
+
+	requests, err := env.server.Await(ctx, 3)
+	assert.Nil(t, err)
+
+	texts := make(map[int64][]string)
+	for _, request := range requests {
+		assert.Equal(t, "sendMessage", request.Method)
+		texts[request.ChatID()] = append(texts[request.ChatID()], request.Text())
+	}
+
+	assert.Equal(t, []string{"first", "second"}, texts[-100])
+	assert.Len(t, texts[1], 1)
+	assert.Contains(t, texts[1][0], "test/a")
+}
+
+func TestCommandListener_Forbidden(t *testing.T) {
+	env := newTestEnv(t, new(testVendor))
+	defer env.Close()
+
+	err := env.listener.OnCommand(context.Background(), env.bot, telegram.Command{
+		Chat:    &telegram.Chat{ID: 2},
+		User:    &telegram.User{ID: 2},
+		Message: new(telegram.Message),
+		Key:     "/sub",
+		Args:    []string{"test/a"},
+	})
+	assert.Equal(t, feed.ErrForbidden, err)
+	assert.Empty(t, env.server.Requests())
+}
+
+func TestCommandListener_Preview(t *testing.T) {
+	env := newTestEnv(t, &testVendor{updates: []string{"first", "second", "third", "fourth"}})
+	defer env.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := env.listener.OnCommand(ctx, env.bot, telegram.Command{
+		Chat:    &telegram.Chat{ID: 1},
+		User:    &telegram.User{ID: 1},
+		Message: new(telegram.Message),
+		Key:     "/preview",
+		Args:    []string{"test/a"},
+	})
+	assert.Nil(t, err)
+
+	requests := env.server.Requests("sendMessage")
+	texts := make([]string, len(requests))
+	for i, request := range requests {
+		assert.Equal(t, int64(1), request.ChatID())
+		texts[i] = request.Text()
+	}
+
+	assert.Equal(t, []string{"first", "second", "third", "test/a: 3 updates previewed"}, texts)
+
+	subs, err := env.store.ListSubs(ctx, 1, true)
+	assert.Nil(t, err)
+	assert.Empty(t, subs)
+}
+
+func TestCommandListener_Replay(t *testing.T) {
+	env := newTestEnv(t, &testVendor{updates: []string{"first", "second", "third"}})
+	defer env.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := env.listener.OnCommand(ctx, env.bot, telegram.Command{
+		Chat:    &telegram.Chat{ID: 1},
+		User:    &telegram.User{ID: 1},
+		Message: new(telegram.Message),
+		Key:     "/sub",
+		Args:    []string{"test/a", "-100"},
+	})
+	assert.Nil(t, err)
+
+	_, err = env.server.Await(ctx, 4)
+	assert.Nil(t, err)
+
+	err = env.listener.OnCommand(ctx, env.bot, telegram.Command{
+		Chat:    &telegram.Chat{ID: 1},
+		User:    &telegram.User{ID: 1},
+		Message: new(telegram.Message),
+		Key:     "/replay",
+		Args:    []string{"-100+test+test/a", "2", "-200"},
+	})
+	assert.Nil(t, err)
+
+	err = env.listener.OnCommand(ctx, env.bot, telegram.Command{
+		Chat:    &telegram.Chat{ID: 1},
+		User:    &telegram.User{ID: 1},
+		Message: new(telegram.Message),
+		Key:     "/replay",
+		Args:    []string{"-100+test+test/a", "10", "-300"},
+	})
+	assert.Nil(t, err)
+
+	texts := make(map[int64][]string)
+	for _, request := range env.server.Requests("sendMessage") {
+		texts[request.ChatID()] = append(texts[request.ChatID()], request.Text())
+	}
+
+	assert.Equal(t, []string{"second", "third"}, texts[-200])
+	assert.Equal(t, []string{"first", "second", "third"}, texts[-300])
+	assert.Equal(t, []string{"test/a: 2 updates replayed", "test/a: 3 updates replayed"}, texts[1][len(texts[1])-2:])
+}
@@ -0,0 +1,91 @@
+package feed
+
+import "math/bits"
+
+// HammingDistance returns the amount of differing bits in two hashes.
+// Hashes of different length are treated as completely different.
+func HammingDistance(a, b []byte) int {
+	if len(a) != len(b) {
+		return 8 * (len(a) + len(b))
+	}
+
+	distance := 0
+	for i := range a {
+		distance += bits.OnesCount8(a[i] ^ b[i])
+	}
+
+	return distance
+}
+
+// BKTree is a metric tree for near-neighbour lookups of hashes in Hamming space.
+type BKTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	hash     []byte
+	value    string
+	children map[int]*bkNode
+}
+
+// Add inserts the hash into the tree. Hashes already present are ignored.
+func (t *BKTree) Add(hash []byte, value string) {
+	node := &bkNode{hash: hash, value: value}
+	if t.root == nil {
+		t.root = node
+		t.size++
+		return
+	}
+
+	current := t.root
+	for {
+		distance := HammingDistance(current.hash, hash)
+		if distance == 0 {
+			return
+		}
+
+		child, ok := current.children[distance]
+		if !ok {
+			if current.children == nil {
+				current.children = make(map[int]*bkNode)
+			}
+
+			current.children[distance] = node
+			t.size++
+			return
+		}
+
+		current = child
+	}
+}
+
+// Find returns the nearest hash within maxDistance along with its value.
+func (t *BKTree) Find(hash []byte, maxDistance int) (match []byte, value string, ok bool) {
+	if t.root == nil {
+		return nil, "", false
+	}
+
+	best := maxDistance + 1
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		distance := HammingDistance(node.hash, hash)
+		if distance < best {
+			match, value, best, ok = node.hash, node.value, distance, true
+		}
+
+		for childDistance, child := range node.children {
+			if childDistance >= distance-maxDistance && childDistance <= distance+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	return
+}
+
+func (t *BKTree) Size() int {
+	return t.size
+}
@@ -0,0 +1,81 @@
+package feed_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, feed.HammingDistance([]byte{0xff, 0x00}, []byte{0xff, 0x00}))
+	assert.Equal(t, 3, feed.HammingDistance([]byte{0xff, 0x00}, []byte{0xfe, 0x03}))
+	assert.Equal(t, 24, feed.HammingDistance([]byte{0x00}, []byte{0x00, 0x00}))
+}
+
+func TestBKTree(t *testing.T) {
+	tree := new(feed.BKTree)
+	_, _, ok := tree.Find([]byte{0x00}, 8)
+	assert.False(t, ok)
+
+	tree.Add([]byte{0x00, 0x00}, "a")
+	tree.Add([]byte{0x0f, 0x00}, "b")
+	tree.Add([]byte{0xff, 0xff}, "c")
+	tree.Add([]byte{0x00, 0x00}, "d")
+	assert.Equal(t, 3, tree.Size())
+
+	match, value, ok := tree.Find([]byte{0x01, 0x00}, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "a", value)
+	assert.Equal(t, []byte{0x00, 0x00}, match)
+
+	_, value, ok = tree.Find([]byte{0x1f, 0x00}, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "b", value)
+
+	_, _, ok = tree.Find([]byte{0x3c, 0x3c}, 2)
+	assert.False(t, ok)
+}
+
+func TestNearBlobStorage(t *testing.T) {
+	store := newTestSQLite3(t, new(testClock))
+	defer store.Close()
+
+	ctx := context.Background()
+	_, err := store.Init(ctx)
+	assert.Nil(t, err)
+
+	blobs := &feed.NearBlobStorage{
+		BlobHashStorage: store,
+		Thresholds:      map[string]int{"dhash": 2},
+		Feeds:           map[feed.ID]map[string]int{2: {"dhash": 0}},
+	}
+
+	hash := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	near := []byte{0, 0, 0, 0, 0, 0, 0, 3}
+	far := []byte{0, 0, 0, 0, 0, 0, 0, 7}
+
+	assert.Nil(t, blobs.CheckBlob(ctx, "1", 1, "a", "dhash", hash))
+	err = blobs.CheckBlob(ctx, "1", 1, "b", "dhash", near)
+	assert.True(t, errors.Is(err, format.ErrSkipMedia))
+	assert.Nil(t, blobs.CheckBlob(ctx, "1", 1, "c", "dhash", far))
+
+	// lookups do not record blobs
+	other := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	url, err := blobs.FindBlob(ctx, "1", 1, "d", "dhash", []byte{0, 0, 0, 0, 0, 0, 0, 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "a", url)
+	url, err = blobs.FindBlob(ctx, "1", 1, "d", "dhash", other)
+	assert.Nil(t, err)
+	assert.Equal(t, "", url)
+	url, err = blobs.FindBlob(ctx, "2", 2, "c", "dhash", other)
+	assert.Nil(t, err)
+	assert.Equal(t, "", url)
+	assert.Nil(t, blobs.CheckBlob(ctx, "1", 1, "d", "dhash", other))
+
+	assert.Nil(t, blobs.CheckBlob(ctx, "2", 2, "a", "dhash", hash))
+	assert.Nil(t, blobs.CheckBlob(ctx, "2", 2, "b", "dhash", near))
+}
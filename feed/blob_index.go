@@ -0,0 +1,131 @@
+package feed
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type BlobHash struct {
+	URL  string
+	Hash []byte
+}
+
+type BlobHashStorage interface {
+	BlobStorage
+	BlobHashes(ctx context.Context, scope string, hashType string) ([]BlobHash, error)
+}
+
+// NearBlobStorage detects near-duplicate media hashes via in-memory BK-trees
+// which are lazily loaded from the underlying storage.
+type NearBlobStorage struct {
+	BlobHashStorage
+
+	// Thresholds maps hash types to the maximum Hamming distance per 64 bits of hash
+	// at which media is considered a duplicate. Hash types which are absent are matched exactly.
+	Thresholds map[string]int
+
+	// Feeds overrides Thresholds for specific feeds.
+	Feeds map[ID]map[string]int
+
+	// RefreshInterval is the interval between reloading hashes from the underlying storage,
+	// so that expired blobs are forgotten. Hashes are never reloaded if it is zero.
+	RefreshInterval time.Duration
+
+	trees       map[blobTreeKey]*BKTree
+	refreshTime time.Time
+	mu          sync.Mutex
+}
+
+type blobTreeKey struct {
+	scope    string
+	hashType string
+	size     int
+}
+
+func (s *NearBlobStorage) Threshold(feedID ID, hashType string, size int) int {
+	threshold := s.Thresholds[hashType]
+	if thresholds, ok := s.Feeds[feedID]; ok {
+		if value, ok := thresholds[hashType]; ok {
+			threshold = value
+		}
+	}
+
+	return threshold * size / 8
+}
+
+func (s *NearBlobStorage) CheckBlob(ctx context.Context, scope string, feedID ID, url string, hashType string, hash []byte) error {
+	threshold := s.Threshold(feedID, hashType, len(hash))
+	if threshold <= 0 {
+		return s.BlobHashStorage.CheckBlob(ctx, scope, feedID, url, hashType, hash)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tree, err := s.tree(ctx, blobTreeKey{scope, hashType, len(hash)})
+	if err != nil {
+		return errors.Wrap(err, "load hashes")
+	}
+
+	if match, nearURL, ok := tree.Find(hash, threshold); ok && nearURL != url {
+		// register the collision on the matching blob
+		return s.BlobHashStorage.CheckBlob(ctx, scope, feedID, url, hashType, match)
+	}
+
+	if err := s.BlobHashStorage.CheckBlob(ctx, scope, feedID, url, hashType, hash); err != nil {
+		return err
+	}
+
+	tree.Add(hash, url)
+	return nil
+}
+
+func (s *NearBlobStorage) FindBlob(ctx context.Context, scope string, feedID ID, url string, hashType string, hash []byte) (string, error) {
+	threshold := s.Threshold(feedID, hashType, len(hash))
+	if threshold > 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		tree, err := s.tree(ctx, blobTreeKey{scope, hashType, len(hash)})
+		if err != nil {
+			return "", errors.Wrap(err, "load hashes")
+		}
+
+		if _, nearURL, ok := tree.Find(hash, threshold); ok {
+			return nearURL, nil
+		}
+	}
+
+	return s.BlobHashStorage.FindBlob(ctx, scope, feedID, url, hashType, hash)
+}
+
+func (s *NearBlobStorage) tree(ctx context.Context, key blobTreeKey) (*BKTree, error) {
+	if now := time.Now(); s.RefreshInterval > 0 && now.Sub(s.refreshTime) > s.RefreshInterval {
+		s.trees = nil
+		s.refreshTime = now
+	}
+
+	if tree, ok := s.trees[key]; ok {
+		return tree, nil
+	}
+
+	hashes, err := s.BlobHashes(ctx, key.scope, key.hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := new(BKTree)
+	for _, hash := range hashes {
+		if len(hash.Hash) == key.size {
+			tree.Add(hash.Hash, hash.URL)
+		}
+	}
+
+	if s.trees == nil {
+		s.trees = make(map[blobTreeKey]*BKTree)
+	}
+
+	s.trees[key] = tree
+	return tree, nil
+}
@@ -0,0 +1,232 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w-go/flu/metrics"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned for calls rejected by an open circuit breaker.
+var ErrCircuitOpen = errors.New("circuit open")
+
+var (
+	// DefaultCircuitThreshold is the amount of consecutive failures which opens a circuit by default.
+	DefaultCircuitThreshold = 5
+	// DefaultCircuitCooldown is the time an open circuit rejects calls for by default.
+	DefaultCircuitCooldown = 5 * time.Minute
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitHalfOpen
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// CircuitHealth is the state of a circuit.
+type CircuitHealth struct {
+	Name  string
+	State CircuitState
+	// Failures is the amount of consecutive failures.
+	Failures int
+	// Since is the time of the last state change.
+	Since time.Time
+	// LastError is the last failure message.
+	LastError string
+}
+
+// CircuitBreakers track health of resolvers and converters by name.
+// A circuit opens after Threshold consecutive failures and rejects calls with ErrCircuitOpen for Cooldown.
+// After that a single probe call is let through: the circuit closes if it succeeds and opens again otherwise.
+// It reports circuit_state gauge (0 is closed, 1 is half-open, 2 is open)
+// along with circuit_trips and circuit_rejections counters by name.
+type CircuitBreakers struct {
+	// Threshold is DefaultCircuitThreshold if not positive.
+	Threshold int
+	// Cooldown is DefaultCircuitCooldown if not positive.
+	Cooldown time.Duration
+	Metrics  metrics.Registry
+	circuits map[string]*circuit
+	mu       sync.Mutex
+}
+
+type circuit struct {
+	CircuitHealth
+	probing bool
+}
+
+// Do calls fn unless the circuit is open and records the result.
+// Calls are not tracked if the receiver is nil or the name is empty.
+// Only transport errors, 5xx responses and timeouts are counted as failures (see IsCircuitFailure),
+// other errors such as missing or skipped media mean that the service works.
+// Failures caused by ctx cancellation are not counted.
+func (b *CircuitBreakers) Do(ctx context.Context, name string, fn func() error) error {
+	if b == nil || name == "" {
+		return fn()
+	}
+
+	if !b.allow(name) {
+		b.inc("circuit_rejections", name)
+		return errors.Wrap(ErrCircuitOpen, name)
+	}
+
+	err := fn()
+	result := err
+	if !IsCircuitFailure(err) {
+		result = nil
+	}
+
+	b.complete(name, result, errors.Is(ctx.Err(), context.Canceled))
+	return err
+}
+
+// IsCircuitFailure checks if the error means that the service is unavailable.
+func IsCircuitFailure(err error) bool {
+	if err == nil || errors.Is(err, format.ErrSkipMedia) {
+		return false
+	}
+
+	// url.Error and net.OpError returned by HTTP clients are net.Error
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return true
+	}
+
+	var status fluhttp.StatusCodeError
+	return errors.As(err, &status) && status.Code >= http.StatusInternalServerError
+}
+
+func (b *CircuitBreakers) allow(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(name)
+	switch c.State {
+	case CircuitOpen:
+		if time.Since(c.Since) < b.cooldown() {
+			return false
+		}
+
+		b.set(c, CircuitHalfOpen)
+		c.probing = true
+		return true
+	case CircuitHalfOpen:
+		if c.probing {
+			return false
+		}
+
+		c.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreakers) complete(name string, err error, canceled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(name)
+	if c.State == CircuitHalfOpen {
+		c.probing = false
+	}
+
+	switch {
+	case err == nil:
+		c.Failures = 0
+		if c.State != CircuitClosed {
+			b.set(c, CircuitClosed)
+		}
+	case canceled:
+	default:
+		c.Failures++
+		c.LastError = err.Error()
+		if c.State == CircuitHalfOpen || c.State == CircuitClosed && c.Failures >= b.threshold() {
+			b.set(c, CircuitOpen)
+			b.inc("circuit_trips", name)
+		}
+	}
+}
+
+func (b *CircuitBreakers) get(name string) *circuit {
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+
+	c, ok := b.circuits[name]
+	if !ok {
+		c = &circuit{CircuitHealth: CircuitHealth{Name: name}}
+		b.set(c, CircuitClosed)
+		b.circuits[name] = c
+	}
+
+	return c
+}
+
+func (b *CircuitBreakers) set(c *circuit, state CircuitState) {
+	c.State = state
+	c.Since = time.Now()
+	if b.Metrics != nil {
+		b.Metrics.Gauge("circuit_state", metrics.Labels{"name", c.Name}).Set(float64(state))
+	}
+}
+
+func (b *CircuitBreakers) inc(counter, name string) {
+	if b.Metrics != nil {
+		b.Metrics.Counter(counter, metrics.Labels{"name", name}).Inc()
+	}
+}
+
+func (b *CircuitBreakers) threshold() int {
+	if b.Threshold > 0 {
+		return b.Threshold
+	}
+
+	return DefaultCircuitThreshold
+}
+
+func (b *CircuitBreakers) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+
+	return DefaultCircuitCooldown
+}
+
+// Health returns states of all circuits sorted by name.
+func (b *CircuitBreakers) Health() []CircuitHealth {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	health := make([]CircuitHealth, 0, len(b.circuits))
+	for _, c := range b.circuits {
+		health = append(health, c.CircuitHealth)
+	}
+
+	sort.Slice(health, func(i, j int) bool { return health[i].Name < health[j].Name })
+	return health
+}
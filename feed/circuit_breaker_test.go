@@ -0,0 +1,79 @@
+package feed_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	breakers := &feed.CircuitBreakers{Threshold: 2, Cooldown: 50 * time.Millisecond}
+	ctx := context.Background()
+	calls := 0
+	fail := func() error { calls++; return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("down")} }
+	succeed := func() error { calls++; return nil }
+
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", fail))
+	assert.Nil(t, breakers.Do(ctx, "aconvert", succeed))
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", fail))
+	assert.Equal(t, feed.CircuitClosed, breakers.Health()[0].State)
+
+	// skipped media is not a failure
+	skip := func() error { calls++; return errors.Wrap(format.ErrSkipMedia, "duplicate") }
+	assert.True(t, errors.Is(breakers.Do(ctx, "aconvert", skip), format.ErrSkipMedia))
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", fail))
+	assert.Equal(t, feed.CircuitClosed, breakers.Health()[0].State)
+
+	// errors which do not mean that the service is down are not failures
+	missing := func() error { calls++; return errors.New("no media found") }
+	notFound := func() error { calls++; return fluhttp.StatusCodeError{Code: 404, Text: "not found"} }
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", missing))
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", fail))
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", notFound))
+	assert.Equal(t, feed.CircuitClosed, breakers.Health()[0].State)
+
+	// consecutive failures trip the breaker
+	unavailable := func() error {
+		calls++
+		return errors.Wrap(fluhttp.StatusCodeError{Code: 503, Text: "unavailable"}, "get")
+	}
+
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", unavailable))
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", fail))
+	err := breakers.Do(ctx, "aconvert", succeed)
+	assert.True(t, errors.Is(err, feed.ErrCircuitOpen))
+	assert.Equal(t, 10, calls)
+
+	// other circuits are not affected
+	assert.Nil(t, breakers.Do(ctx, "viddit", succeed))
+	health := breakers.Health()
+	assert.Len(t, health, 2)
+	assert.Equal(t, feed.CircuitOpen, health[0].State)
+	assert.Equal(t, "dial tcp: down", health[0].LastError)
+	assert.Equal(t, feed.CircuitClosed, health[1].State)
+
+	// failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.NotNil(t, breakers.Do(ctx, "aconvert", fail))
+	err = breakers.Do(ctx, "aconvert", succeed)
+	assert.True(t, errors.Is(err, feed.ErrCircuitOpen))
+
+	// successful probe closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, breakers.Do(ctx, "aconvert", succeed))
+	assert.Nil(t, breakers.Do(ctx, "aconvert", succeed))
+	assert.Equal(t, feed.CircuitClosed, breakers.Health()[0].State)
+
+	// unnamed calls are not tracked
+	var nilBreakers *feed.CircuitBreakers
+	assert.Nil(t, nilBreakers.Do(ctx, "aconvert", succeed))
+	assert.Nil(t, breakers.Do(ctx, "", succeed))
+	assert.Len(t, breakers.Health(), 2)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/jfk9w-go/flu"
@@ -123,12 +124,17 @@ func (s *Supervisor) NotifyAdmins(ctx context.Context, chatID telegram.ID, marku
 }
 
 type CommandListener struct {
-	Context    context.Context
-	Aggregator *Aggregator
-	Management Management
-	Aliases    map[string]telegram.ID
-	Metrics    metrics.Registry
-	GitCommit  string
+	Context      context.Context
+	Aggregator   *Aggregator
+	Management   Management
+	Aliases      map[string]telegram.ID
+	Metrics      metrics.Registry
+	GitCommit    string
+	PreviewLimit int
+	DedupScopes  DedupScopeStorage
+	Blobs        BlobStatStorage
+	Breakers     *CircuitBreakers
+	DupesLimit   int
 }
 
 func (c *CommandListener) Init(ctx context.Context) (*CommandListener, error) {
@@ -136,6 +142,14 @@ func (c *CommandListener) Init(ctx context.Context) (*CommandListener, error) {
 		c.Metrics = metrics.DummyRegistry{}
 	}
 
+	if c.PreviewLimit <= 0 {
+		c.PreviewLimit = 3
+	}
+
+	if c.DupesLimit <= 0 {
+		c.DupesLimit = 10
+	}
+
 	return c, c.Aggregator.Init(ctx, c)
 }
 
@@ -158,6 +172,10 @@ func (c *CommandListener) OnCommand(ctx context.Context, client telegram.Client,
 	switch cmd.Key {
 	case "/sub", "/subscribe":
 		fun = c.Subscribe
+	case "/preview":
+		fun = c.Preview
+	case "/replay":
+		fun = c.Replay
 	case suspendCommandKey:
 		fun = c.Suspend
 	case resumeCommandKey:
@@ -166,6 +184,10 @@ func (c *CommandListener) OnCommand(ctx context.Context, client telegram.Client,
 		fun = c.Delete
 	case "/clear":
 		fun = c.Clear
+	case "/dedup":
+		fun = c.Dedup
+	case "/dupes":
+		fun = c.Dupes
 	case "/list":
 		fun = c.List
 	case "/status":
@@ -200,12 +222,34 @@ var (
 		"CHAT_ID – target chat username or '.' to use this chat. Optional, this chat by default.\n" +
 		"OPTIONS – subscription-specific options string. Optional, empty by default.")
 
+	ErrPreviewUsage = errors.Errorf("" +
+		"Usage: /preview SUB [OPTIONS]\n\n" +
+		"SUB – subscription string (for example, a link).\n" +
+		"OPTIONS – subscription-specific options string. Optional, empty by default.")
+
+	ErrReplayUsage = errors.Errorf("" +
+		"Usage: /replay SUB_ID N [CHAT_ID]\n\n" +
+		"SUB_ID – subscription ID as in buttons.\n" +
+		"N – number of last delivered updates to send again.\n" +
+		"CHAT_ID – target chat username or '.' to use this chat. Optional, this chat by default.")
+
 	ErrClearUsage = errors.Errorf("" +
 		"Usage: /clear PATTERN [CHAT_ID]\n\n" +
 		"PATTERN – pattern to match subscription error.\n" +
 		"CHAT_ID – target chat username or '.' to use this chat.",
 	)
 
+	ErrDedupUsage = errors.Errorf("" +
+		"Usage: /dedup [CHAT_ID] [SCOPE]\n\n" +
+		"CHAT_ID – target chat username or '.' to use this chat. Optional, this chat by default.\n" +
+		"SCOPE – 'chat' to detect duplicate media within this chat only, 'global' to share duplicates " +
+		"with all global chats, or a group name to share duplicates with chats in the same group. " +
+		"Optional, shows the current scope by default.")
+
+	ErrDupesUsage = errors.Errorf("" +
+		"Usage: /dupes [CHAT_ID]\n\n" +
+		"CHAT_ID – target chat username or '.' to use this chat. Optional, this chat by default.")
+
 	ErrListUsage = errors.Errorf("" +
 		"Usage: /list [CHAT_ID] [STATUS]\n\n" +
 		"CHAT_ID – target chat username or '.' to use this chat. Optional, this chat by default.\n" +
@@ -289,6 +333,48 @@ func (c *CommandListener) OnSubscribe(sub Sub) {
 		})
 }
 
+func (c *CommandListener) Preview(ctx context.Context, client telegram.Client, cmd telegram.Command) error {
+	if len(cmd.Args) == 0 {
+		return ErrPreviewUsage
+	}
+	ctx, err := c.Management.CheckAccess(ctx, cmd.User.ID, cmd.Chat.ID)
+	if err != nil {
+		return err
+	}
+
+	sub, count, err := c.Aggregator.Preview(ctx, ID(cmd.Chat.ID), cmd.Args[0], cmd.Args[1:], c.PreviewLimit)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Reply(ctx, client, fmt.Sprintf("%s: %d updates previewed", sub.Name, count))
+}
+
+func (c *CommandListener) Replay(ctx context.Context, client telegram.Client, cmd telegram.Command) error {
+	if len(cmd.Args) < 2 {
+		return ErrReplayUsage
+	}
+	count, err := strconv.Atoi(cmd.Args[1])
+	if err != nil || count <= 0 {
+		return ErrReplayUsage
+	}
+	ctx, subID, err := c.parseSubID(ctx, cmd, 0)
+	if err != nil {
+		return err
+	}
+	ctx, chatID, err := c.resolveChatID(ctx, client, cmd, 2)
+	if err != nil {
+		return err
+	}
+
+	sub, count, err := c.Aggregator.Replay(ctx, subID, count, ID(chatID))
+	if err != nil {
+		return err
+	}
+
+	return cmd.Reply(ctx, client, fmt.Sprintf("%s: %d updates replayed", sub.Name, count))
+}
+
 func (c *CommandListener) Suspend(ctx context.Context, _ telegram.Client, cmd telegram.Command) error {
 	ctx, subID, err := c.parseSubID(ctx, cmd, 0)
 	if err != nil {
@@ -397,6 +483,97 @@ func (c *CommandListener) OnClear(feedID ID, pattern string, count int64) {
 		})
 }
 
+const chatDedupScope = "chat"
+
+func parseDedupScope(feedID ID, value string) (string, error) {
+	switch {
+	case value == chatDedupScope:
+		return ChatDedupScope(feedID), nil
+	case value == GlobalDedupScope:
+		return value, nil
+	case len(value) > 63:
+		return "", errors.New("group name is too long")
+	}
+
+	if _, err := ParseID(value); err == nil {
+		return "", errors.New("group name should not be a number")
+	}
+
+	return value, nil
+}
+
+func (c *CommandListener) Dedup(ctx context.Context, client telegram.Client, cmd telegram.Command) error {
+	if len(cmd.Args) > 2 {
+		return ErrDedupUsage
+	}
+	if c.DedupScopes == nil {
+		return errors.New("dedup scopes are not supported")
+	}
+	ctx, chatID, err := c.resolveChatID(ctx, client, cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	feedID := ID(chatID)
+	if len(cmd.Args) > 1 {
+		scope, err := parseDedupScope(feedID, cmd.Args[1])
+		if err != nil {
+			return err
+		}
+		if err := c.DedupScopes.SetDedupScope(ctx, feedID, scope); err != nil {
+			return err
+		}
+	}
+
+	scope, err := c.DedupScopes.GetDedupScope(ctx, feedID)
+	if err != nil {
+		return err
+	}
+	if scope == ChatDedupScope(feedID) {
+		return cmd.Reply(ctx, client, "Dedup scope: "+chatDedupScope)
+	}
+
+	feedIDs, err := c.DedupScopes.ListDedupScope(ctx, scope)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Reply(ctx, client, fmt.Sprintf("Dedup scope: %s (%d chats)", scope, len(feedIDs)))
+}
+
+func (c *CommandListener) Dupes(ctx context.Context, client telegram.Client, cmd telegram.Command) error {
+	if len(cmd.Args) > 1 {
+		return ErrDupesUsage
+	}
+	if c.Blobs == nil {
+		return errors.New("blob stats are not supported")
+	}
+	ctx, chatID, err := c.resolveChatID(ctx, client, cmd, 0)
+	if err != nil {
+		return err
+	}
+
+	stats, err := c.Blobs.BlobPage(ctx, ID(chatID), 0, uint(c.DupesLimit))
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%d dupes @ %s",
+		len(stats), format.HTMLAnchor("chat", c.Management.GetChatLink(ctx, chatID)))
+	for i, stat := range stats {
+		text += fmt.Sprintf("\n%d. %s – %d times, last %s",
+			i+1, format.HTMLAnchor("media", stat.URL), stat.Collisions, stat.LastSeen.Format("2006-01-02 15:04"))
+	}
+
+	_, err = client.Send(ctx, cmd.Chat.ID,
+		telegram.Text{
+			ParseMode:             telegram.HTML,
+			Text:                  text,
+			DisableWebPagePreview: true},
+		&telegram.SendOptions{ReplyToMessageID: cmd.Message.ID})
+	return err
+}
+
 func (c *CommandListener) List(ctx context.Context, client telegram.Client, cmd telegram.Command) error {
 	ctx, chatID, err := c.resolveChatID(ctx, client, cmd, 0)
 	if err != nil {
@@ -443,7 +620,7 @@ func (c *CommandListener) List(ctx context.Context, client telegram.Client, cmd
 }
 
 func (c *CommandListener) Status(ctx context.Context, client telegram.Client, cmd telegram.Command) error {
-	return cmd.Reply(ctx, client, fmt.Sprintf("OK\n"+
+	text := fmt.Sprintf("OK\n"+
 		"User ID: %s\n"+
 		"Chat ID: %s\n"+
 		"Message ID: %s\n"+
@@ -452,5 +629,24 @@ func (c *CommandListener) Status(ctx context.Context, client telegram.Client, cm
 		"Commit: %s\n",
 		cmd.User.ID, cmd.Chat.ID, cmd.Message.ID,
 		client.Username(), time.Now().Format("2006-01-02 15:04:05"),
-		c.GitCommit))
+		c.GitCommit)
+
+	if health := c.Breakers.Health(); len(health) > 0 {
+		text += "Circuits:\n"
+		healthy := 0
+		for _, circuit := range health {
+			if circuit.State == CircuitClosed && circuit.Failures == 0 {
+				healthy++
+				continue
+			}
+
+			text += fmt.Sprintf("  %s: %s since %s (%d failures, last: %s)\n",
+				circuit.Name, circuit.State, circuit.Since.Format("2006-01-02 15:04:05"),
+				circuit.Failures, circuit.LastError)
+		}
+
+		text += fmt.Sprintf("  %d closed without failures\n", healthy)
+	}
+
+	return cmd.Reply(ctx, client, text)
 }
@@ -103,9 +103,12 @@ type Sub struct {
 type WriteHTML func(html *format.HTMLWriter) error
 
 type Update struct {
+	// Write may be nil for updates which only advance Data, e.g. when an item is skipped.
 	Write WriteHTML
 	Data  interface{}
 	Error error
+	// Delivered is called after the update is sent to the feed, if not nil.
+	Delivered func(ctx context.Context) error
 }
 
 type SubDraft struct {
@@ -131,8 +134,71 @@ type SubStorage interface {
 	UpdateSub(ctx context.Context, id SubID, value interface{}) error
 }
 
+// Delivery contains messages of a delivered update as they were sent to the feed.
+type Delivery []DeliveredMessage
+
+// DeliveredMessage is either a text page or a media message with Text as caption.
+type DeliveredMessage struct {
+	Text                  string `json:"text,omitempty"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview,omitempty"`
+	// MIMEType and URL are set for media. URL is either a remote media URL or a Telegram file_id.
+	MIMEType    string `json:"mime_type,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Collapsible bool   `json:"collapsible,omitempty"`
+}
+
+type HistoryStorage interface {
+	SaveHistory(ctx context.Context, id SubID, delivery Delivery) error
+	// GetHistory returns at most limit last deliveries of the subscription, the oldest first.
+	GetHistory(ctx context.Context, id SubID, limit int) ([]Delivery, error)
+}
+
 type BlobStorage interface {
-	CheckBlob(ctx context.Context, feedID ID, url string, hashType string, hash []byte) error
+	CheckBlob(ctx context.Context, scope string, feedID ID, url string, hashType string, hash []byte) error
+	// FindBlob returns the URL of a blob matching the url or the hash without recording anything.
+	// It returns an empty string if there is no match.
+	FindBlob(ctx context.Context, scope string, feedID ID, url string, hashType string, hash []byte) (string, error)
+}
+
+// GlobalDedupScope is shared by all feeds assigned to it.
+// Any other scope which is not a feed ID is a named group of feeds.
+const GlobalDedupScope = "global"
+
+// ChatDedupScope returns the default dedup scope of the feed which contains only this feed.
+func ChatDedupScope(feedID ID) string {
+	return PrintID(feedID)
+}
+
+type BlobStat struct {
+	URL        string    `db:"url"`
+	Collisions int       `db:"collisions"`
+	LastSeen   time.Time `db:"last_seen"`
+}
+
+type BlobStatStorage interface {
+	BlobPage(ctx context.Context, feedID ID, offset, limit uint) ([]BlobStat, error)
+}
+
+type DedupScopeStorage interface {
+	GetDedupScope(ctx context.Context, feedID ID) (string, error)
+	SetDedupScope(ctx context.Context, feedID ID, scope string) error
+	ListDedupScope(ctx context.Context, scope string) ([]ID, error)
+}
+
+// FileID is a Telegram file_id of media sent before.
+type FileID struct {
+	URL string `db:"url"`
+	// Hash is the hex-encoded SHA-256 hash of the original media contents.
+	Hash     string `db:"hash"`
+	MIMEType string `db:"mime_type"`
+	FileID   string `db:"file_id"`
+}
+
+type FileIDStorage interface {
+	// GetFileID looks up a file_id by media URL and then by content hash if it is not empty.
+	// It returns nil if none is found.
+	GetFileID(ctx context.Context, url, hash string) (*FileID, error)
+	SetFileID(ctx context.Context, fileID FileID) error
 }
 
 type Queue struct {
@@ -159,3 +225,7 @@ func (q Queue) Submit(ctx context.Context, update Update) error {
 func (q Queue) Close() {
 	close(q.channel)
 }
+
+func (q Queue) Updates() <-chan Update {
+	return q.channel
+}
@@ -0,0 +1,214 @@
+package feed
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/flu/metrics"
+	"github.com/jfk9w-go/flu/serde"
+)
+
+// HostLimit limits media jobs for a host.
+type HostLimit struct {
+	// Concurrency is the maximum amount of simultaneous jobs. Unlimited if not positive.
+	Concurrency int
+	// Interval is the minimum interval between job starts. Optional.
+	Interval serde.Duration
+}
+
+// DefaultHostLimits are media host limits by domain. Subdomains share limits of the domain.
+var DefaultHostLimits = map[string]HostLimit{
+	"2ch.hk":      {Concurrency: 3},
+	"i.redd.it":   {Concurrency: 3},
+	"reddit.com":  {Concurrency: 2},
+	"imgur.com":   {Concurrency: 2, Interval: serde.Duration{Duration: 500 * time.Millisecond}},
+	"gfycat.com":  {Concurrency: 1, Interval: serde.Duration{Duration: time.Second}},
+	"redgifs.com": {Concurrency: 1, Interval: serde.Duration{Duration: time.Second}},
+	"youtube.com": {Concurrency: 1},
+	"youtu.be":    {Concurrency: 1},
+}
+
+// DefaultHostLimit is used for hosts without a limit. Every such host gets its own queue.
+var DefaultHostLimit = HostLimit{Concurrency: 2}
+
+// AnyHost is the HostLimiter.Limits key which overrides DefaultHostLimit.
+const AnyHost = "*"
+
+// HostLimiter queues media jobs by host.
+// Queues are dropped once they are idle.
+// It reports host_queue gauge along with host_jobs and host_wait_seconds counters by host.
+type HostLimiter struct {
+	// Limits override DefaultHostLimits.
+	Limits   map[string]HostLimit
+	Metrics  metrics.Registry
+	limiters map[string]*hostLimiter
+	mu       sync.Mutex
+}
+
+// Start waits until a job for the url is allowed to start.
+// The returned function must be called when the job is completed.
+func (l *HostLimiter) Start(ctx context.Context, rawURL string) (func(), error) {
+	host, limiter := l.get(rawURL)
+	labels := metrics.Labels{"host", host}
+	if l.Metrics != nil {
+		l.Metrics.Gauge("host_queue", labels).Inc()
+		defer l.Metrics.Gauge("host_queue", labels).Dec()
+	}
+
+	start := time.Now()
+	release, err := limiter.start(ctx)
+	if err != nil {
+		l.release(limiter)
+		return nil, err
+	}
+
+	complete := func() {
+		release()
+		l.release(limiter)
+	}
+
+	if l.Metrics != nil {
+		l.Metrics.Counter("host_jobs", labels).Inc()
+		l.Metrics.Counter("host_wait_seconds", labels).Add(time.Since(start).Seconds())
+	}
+
+	return complete, nil
+}
+
+func (l *HostLimiter) get(rawURL string) (string, *hostLimiter) {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	host = strings.ToLower(host)
+	key := host
+	limit, ok := l.Limits[AnyHost]
+	if !ok {
+		limit = DefaultHostLimit
+	}
+
+	for domain := host; domain != ""; {
+		if domainLimit, ok := l.lookup(domain); ok {
+			key, limit = domain, domainLimit
+			break
+		}
+
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+
+		domain = domain[dot+1:]
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limiters == nil {
+		l.limiters = make(map[string]*hostLimiter)
+	}
+
+	limiter, ok := l.limiters[key]
+	if !ok {
+		now := time.Now()
+		for key, limiter := range l.limiters {
+			if limiter.idle(now) {
+				delete(l.limiters, key)
+			}
+		}
+
+		limiter = newHostLimiter(limit)
+		l.limiters[key] = limiter
+	}
+
+	limiter.jobs++
+	return key, limiter
+}
+
+// Queues returns the amount of host queues.
+func (l *HostLimiter) Queues() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.limiters)
+}
+
+func (l *HostLimiter) release(limiter *hostLimiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter.jobs--
+}
+
+func (l *HostLimiter) lookup(domain string) (HostLimit, bool) {
+	if limit, ok := l.Limits[domain]; ok {
+		return limit, true
+	}
+
+	limit, ok := DefaultHostLimits[domain]
+	return limit, ok
+}
+
+type hostLimiter struct {
+	slots    chan struct{}
+	interval time.Duration
+	next     time.Time
+	mu       sync.Mutex
+	// jobs is the amount of queued and running jobs guarded by HostLimiter.mu.
+	jobs int
+}
+
+func newHostLimiter(limit HostLimit) *hostLimiter {
+	limiter := &hostLimiter{interval: limit.Interval.Duration}
+	if limit.Concurrency > 0 {
+		limiter.slots = make(chan struct{}, limit.Concurrency)
+	}
+
+	return limiter
+}
+
+// idle checks if the limiter has no jobs and does not delay the next one.
+func (l *hostLimiter) idle(now time.Time) bool {
+	if l.jobs > 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.next.After(now)
+}
+
+func (l *hostLimiter) start(ctx context.Context) (func(), error) {
+	release := func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+			release = func() { <-l.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+
+		wait := l.next.Sub(now)
+		l.next = l.next.Add(l.interval)
+		l.mu.Unlock()
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	return release, nil
+}
@@ -0,0 +1,46 @@
+package feed_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostLimiter(t *testing.T) {
+	limiter := &feed.HostLimiter{
+		Limits: map[string]feed.HostLimit{
+			"example.com": {Concurrency: 1},
+			feed.AnyHost:  {Concurrency: 1},
+		},
+	}
+
+	ctx := context.Background()
+	complete, err := limiter.Start(ctx, "https://example.com/a.jpg")
+	assert.Nil(t, err)
+
+	// other hosts are not blocked
+	other, err := limiter.Start(ctx, "https://other.org/a.jpg")
+	assert.Nil(t, err)
+
+	// subdomains share the domain queue
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Start(timeout, "https://i.example.com/b.jpg")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	complete()
+	complete, err = limiter.Start(ctx, "https://i.example.com/b.jpg")
+	assert.Nil(t, err)
+	complete()
+
+	// idle queues are dropped
+	assert.Equal(t, 2, limiter.Queues())
+	other()
+	complete, err = limiter.Start(ctx, "https://third.net/c.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, limiter.Queues())
+	complete()
+}
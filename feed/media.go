@@ -11,6 +11,7 @@ import (
 	"github.com/jfk9w-go/flu/metrics"
 	telegram "github.com/jfk9w-go/telegram-bot-api"
 	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/botapi"
 	"github.com/pkg/errors"
 )
 
@@ -20,7 +21,14 @@ type (
 		ResolveURL(ctx context.Context, client *fluhttp.Client, url string, maxSize int64) (string, error)
 	}
 
+	// MediaResolvers sets up resolvers for media references submitted without one.
+	MediaResolvers interface {
+		Setup(ref *MediaRef)
+	}
+
 	MediaConverter interface {
+		// Name identifies the converter in CircuitBreakers.
+		Name() string
 		MIMETypes() []string
 		Convert(ctx context.Context, ref *MediaRef) (format.MediaRef, error)
 	}
@@ -28,6 +36,27 @@ type (
 	MediaDedup interface {
 		Check(ctx context.Context, feedID ID, url, mimeType string, blob format.Blob) error
 	}
+
+	// TextDedup checks texts when updates are loaded and records them once they are delivered.
+	TextDedup interface {
+		CheckText(ctx context.Context, feedID ID, url, text string) error
+		RecordText(ctx context.Context, feedID ID, url, text string) error
+	}
+
+	// MediaDownscaler makes downloaded or converted media fit Telegram limits.
+	// It returns nil ref if the media fits the limits already.
+	MediaDownscaler interface {
+		// CanDownscale reports whether media of this type and size may be downscaled,
+		// so that it is worth downloading media which exceeds the limits.
+		CanDownscale(mimeType string, size int64) bool
+		// Downscale downscales the blob of mimeType. The type may differ from ref.MIMEType for converted media.
+		Downscale(ctx context.Context, ref *MediaRef, mimeType string, blob format.Blob, size int64) (format.MediaRef, error)
+	}
+
+	// MediaProber fills in missing video metadata and generates a video thumbnail.
+	MediaProber interface {
+		Probe(ctx context.Context, blob format.Blob, metadata *MediaMetadata) (thumbnail []byte, err error)
+	}
 )
 
 type DummyMediaResolver struct {
@@ -48,14 +77,24 @@ func (r DummyMediaResolver) Request(request *fluhttp.Request) *fluhttp.Request {
 
 type MediaManager struct {
 	DefaultClient *fluhttp.Client
+	Resolvers     MediaResolvers
 	SizeBounds    [2]int64
 	Storage       format.Blobs
 	Converters    map[string]MediaConverter
+	PageResolver  MediaPageResolver
+	Breakers      *CircuitBreakers
 	Dedup         MediaDedup
+	Downscaler    MediaDownscaler
+	Prober        MediaProber
+	Cache         MediaCache
+	FileIDs       FileIDStorage
+	NoFileIDs     map[ID]bool
+	HostLimiter   *HostLimiter
 	RateLimiter   flu.RateLimiter
 	Metrics       metrics.Registry
 	Retries       int
 	CURL          string
+	AlbumLimit    int
 	ctx           context.Context
 	cancel        func()
 	work          sync.WaitGroup
@@ -72,27 +111,71 @@ func (m *MediaManager) Init(ctx context.Context) *MediaManager {
 	return m
 }
 
+func (m *MediaManager) setup(ref *MediaRef) {
+	ref.Manager = m
+	if ref.MediaResolver == nil {
+		if m.Resolvers != nil {
+			m.Resolvers.Setup(ref)
+		} else {
+			ref.MediaResolver = DummyMediaResolver{}
+		}
+	}
+}
+
 func (m *MediaManager) Submit(ref *MediaRef) format.MediaRef {
 	m.work.Add(1)
-	mvar := format.NewMediaVar()
+	mvar := newMediaVar()
 	ctx, cancel := context.WithTimeout(m.ctx, 10*time.Minute)
-	ref.Manager = m
+	m.setup(ref)
 	go func() {
 		defer m.work.Done()
 		defer cancel()
-		if err := m.RateLimiter.Start(ctx); err != nil {
-			log.Printf("[media > %s] failed to process: %s", ref.URL, err)
-			return
+		if m.HostLimiter != nil {
+			complete, err := m.HostLimiter.Start(ctx, ref.URL)
+			if err != nil {
+				log.Printf("[media > %s] failed to process: %s", ref.URL, err)
+				mvar.set(Media{}, err)
+				return
+			}
+
+			defer complete()
 		}
 
-		defer m.RateLimiter.Complete()
-		media, err := ref.Get(ctx)
-		mvar.Set(media, err)
+		if m.RateLimiter != nil {
+			if err := m.RateLimiter.Start(ctx); err != nil {
+				log.Printf("[media > %s] failed to process: %s", ref.URL, err)
+				mvar.set(Media{}, err)
+				return
+			}
+
+			defer m.RateLimiter.Complete()
+		}
+		mvar.set(ref.getMedia(ctx))
 	}()
 
 	return mvar
 }
 
+// CheckText returns an error wrapping format.ErrSkipMedia if a similar text has already been sent to the feed.
+// The text is not recorded, see RecordText.
+// Texts are not checked if Dedup does not implement TextDedup or the context is a preview one.
+func (m *MediaManager) CheckText(ctx context.Context, feedID ID, url, text string) error {
+	if dedup, ok := m.Dedup.(TextDedup); ok && !IsPreview(ctx) {
+		return dedup.CheckText(ctx, feedID, url, text)
+	}
+
+	return nil
+}
+
+// RecordText records the text as sent to the feed. It should be called only after the text is delivered.
+func (m *MediaManager) RecordText(ctx context.Context, feedID ID, url, text string) error {
+	if dedup, ok := m.Dedup.(TextDedup); ok && !IsPreview(ctx) {
+		return dedup.RecordText(ctx, feedID, url, text)
+	}
+
+	return nil
+}
+
 func (m *MediaManager) Converter(converter MediaConverter) *MediaManager {
 	if m.Converters == nil {
 		m.Converters = map[string]MediaConverter{}
@@ -112,6 +195,68 @@ func (m *MediaManager) Close() {
 
 const UnknownSize int64 = -1
 
+// Media is the media resolved by MediaManager along with its video attributes.
+type Media struct {
+	format.Media
+	botapi.Attributes
+	// ref is used for recording file_id of the media once it is sent.
+	ref *MediaRef
+}
+
+// sent records file_id of the sent media in Manager.FileIDs.
+// Nothing is recorded for previews.
+func (m Media) sent(ctx context.Context, fileID string) {
+	if m.ref == nil || fileID == "" || IsPreview(ctx) {
+		return
+	}
+
+	storage := m.ref.Manager.FileIDs
+	if storage == nil || m.ref.Manager.NoFileIDs[m.ref.FeedID] {
+		return
+	}
+
+	if err := storage.SetFileID(ctx, FileID{
+		URL:      m.ref.URL,
+		Hash:     m.ref.Hash,
+		MIMEType: m.MIMEType,
+		FileID:   fileID,
+	}); err != nil {
+		log.Printf("[media > %s] failed to save file id: %s", m.ref.URL, err)
+	}
+}
+
+// MediaVar is a format.MediaRef to the media submitted to MediaManager.
+// Transports created by TelegramHTML use Resolve to send video attributes along with the media.
+type MediaVar struct {
+	media Media
+	err   error
+	done  chan struct{}
+}
+
+func newMediaVar() *MediaVar {
+	return &MediaVar{done: make(chan struct{})}
+}
+
+func (v *MediaVar) set(media Media, err error) {
+	v.media, v.err = media, err
+	close(v.done)
+}
+
+// Resolve waits for the media to be processed.
+func (v *MediaVar) Resolve(ctx context.Context) (Media, error) {
+	select {
+	case <-ctx.Done():
+		return Media{}, ctx.Err()
+	case <-v.done:
+		return v.media, v.err
+	}
+}
+
+func (v *MediaVar) Get(ctx context.Context) (format.Media, error) {
+	media, err := v.Resolve(ctx)
+	return media.Media, err
+}
+
 type MediaRef struct {
 	MediaResolver
 	Manager     *MediaManager
@@ -120,15 +265,31 @@ type MediaRef struct {
 	Blob        bool
 	FeedID      ID
 	ResolvedURL string
+	// Thumbnail is a JPEG video thumbnail. It is generated by MediaManager.Prober if not set.
+	Thumbnail []byte
+	// Hash is the content hash set by MediaManager.Cache after download.
+	Hash string
+	// ResolverName identifies the resolver in MediaManager.Breakers. Optional.
+	ResolverName string
+	// Headers are sent with requests for ResolvedURL. Optional.
+	Headers map[string]string
 	MediaMetadata
+	candidates []MediaCandidate
+	cacheKey   string
+	page       bool
 }
 
 func (r *MediaRef) getClient() *fluhttp.Client {
-	if r.GetClient() != nil {
-		return r.GetClient()
-	} else {
-		return r.Manager.DefaultClient
+	client := r.GetClient()
+	if client == nil {
+		client = r.Manager.DefaultClient
+	}
+
+	if len(r.Headers) > 0 {
+		client = withHeaders(client, r.Headers)
 	}
+
+	return client
 }
 
 func (r *MediaRef) incrementMediaMethod(mimeType string, method string) {
@@ -147,96 +308,357 @@ func (r *MediaRef) incrementMediaError(mimeType string, err string) {
 	}).Inc()
 }
 
+// Download writes resolved media contents to out and returns the amount of bytes written.
+// Contents are looked up in Manager.Cache by URL first and stored there after download.
+func (r *MediaRef) Download(ctx context.Context, out flu.Output) (int64, error) {
+	cache := r.Manager.Cache
+	key := r.cacheKey
+	if key == "" {
+		key = r.URL
+	}
+
+	if cache != nil {
+		hash, size, err := cache.Get(key, out)
+		if err != nil {
+			log.Printf("[media > %s] failed to read from cache: %s", r.URL, err)
+		} else if hash != "" {
+			r.Hash = hash
+			r.Manager.Metrics.Counter("cache_hit", metrics.Labels{"feed_id", PrintID(r.FeedID)}).Inc()
+			return size, nil
+		}
+	}
+
+	client := NewMediaClient(r.getClient(), r.Manager.CURL, r.Manager.Retries, r.Manager.SizeBounds[1])
+	size, err := client.Download(ctx, r.ResolvedURL, out)
+	if err != nil {
+		return size, err
+	}
+
+	if in, ok := out.(flu.Input); ok && cache != nil {
+		hash, err := cache.Put(key, in)
+		if err != nil {
+			log.Printf("[media > %s] failed to write to cache: %s", r.URL, err)
+		} else {
+			r.Hash = hash
+		}
+	}
+
+	return size, nil
+}
+
 func (r *MediaRef) Get(ctx context.Context) (format.Media, error) {
+	media, err := r.getMedia(ctx)
+	return media.Media, err
+}
+
+// getMedia gets the media along with its video attributes.
+func (r *MediaRef) getMedia(ctx context.Context) (Media, error) {
 	media, err := r.doGet(ctx)
 	if err != nil {
-		return format.Media{}, errors.Wrapf(err, "with resolved URL %s", r.ResolvedURL)
+		return Media{}, errors.Wrapf(err, "with resolved URL %s", r.ResolvedURL)
 	}
 
 	return media, nil
 }
 
-func (r *MediaRef) doGet(ctx context.Context) (format.Media, error) {
-	var err error
-	r.ResolvedURL, err = r.ResolveURL(ctx, r.getClient(), r.URL, telegram.Video.AttachMaxSize())
-	if err != nil {
+func (r *MediaRef) doGet(ctx context.Context) (Media, error) {
+	if !r.Dedup {
+		if media, ok := r.getFileID(ctx); ok {
+			return media, nil
+		}
+	}
+
+	candidates, err := r.resolve(ctx)
+	if errors.Is(err, ErrCircuitOpen) {
+		// the original link is sent instead of the media
+		r.incrementMediaError("unknown", "circuit open")
+		return Media{}, errors.Wrapf(err, "resolve url: %s", r.URL)
+	} else if err != nil {
 		r.incrementMediaError("unknown", "resolve url")
-		return format.Media{}, errors.Wrapf(err, "resolve url: %s", r.URL)
+		return Media{}, errors.Wrapf(err, "resolve url: %s", r.URL)
 	}
 
-	client := NewMediaClient(r.getClient(), r.Manager.CURL, r.Manager.Retries)
+	return r.getCandidates(ctx, candidates)
+}
+
+// getCandidates gets the first candidate which succeeds.
+func (r *MediaRef) getCandidates(ctx context.Context, candidates []MediaCandidate) (media Media, err error) {
+	for i, candidate := range candidates {
+		r.use(candidate, len(candidates) > 1)
+		media, err = r.getCandidate(ctx)
+		if err == nil || errors.Is(err, format.ErrSkipMedia) || ctx.Err() != nil {
+			return media, err
+		}
+
+		if i < len(candidates)-1 {
+			log.Printf("[media > %s] failed to get %s, falling back to the next candidate: %s", r.URL, r.ResolvedURL, err)
+			r.incrementMediaError(r.MIMEType, "candidate")
+		}
+	}
+
+	return Media{}, err
+}
+
+// getCandidate gets the media from ResolvedURL.
+func (r *MediaRef) getCandidate(ctx context.Context) (Media, error) {
 	if r.MIMEType == "" && r.Size == 0 {
-		if m, err := client.Metadata(ctx, r.ResolvedURL); err != nil {
-			r.incrementMediaError("unknown", "head")
-			return format.Media{}, errors.Wrap(err, "head")
-		} else {
-			r.MediaMetadata = *m
+		client := NewMediaClient(r.getClient(), r.Manager.CURL, r.Manager.Retries, 0)
+		m, err := client.Metadata(ctx, r.ResolvedURL)
+		if err != nil || !isMediaMIMEType(m.MIMEType) && !r.isPage(m.MIMEType) {
+			declared := ""
+			if err == nil {
+				declared = m.MIMEType
+			}
+
+			sniffed, data, sniffErr := client.Sniff(ctx, r.ResolvedURL)
+			if sniffErr != nil {
+				if err == nil {
+					err = sniffErr
+				}
+
+				r.incrementMediaError("unknown", "head")
+				return Media{}, errors.Wrap(err, "head")
+			}
+
+			if declared == "" {
+				declared = sniffed.MIMEType
+			}
+
+			sniffed.MIMEType = r.sniffMIMEType(declared, data)
+			if err == nil && sniffed.Size == UnknownSize {
+				sniffed.Size = m.Size
+			}
+
+			m = sniffed
 		}
 
+		if r.isPage(m.MIMEType) {
+			return r.getPage(ctx)
+		}
+
+		r.Size, r.MIMEType = m.Size, m.MIMEType
+
 		if r.Size != UnknownSize {
 			if r.Size < r.Manager.SizeBounds[0] {
 				r.incrementMediaError(r.MIMEType, "too small")
-				return format.Media{}, errors.Errorf("size of %db is too low", r.Size)
+				return Media{}, errors.Errorf("size of %db is too low", r.Size)
 			} else if r.Size > r.Manager.SizeBounds[1] {
 				r.incrementMediaError(r.MIMEType, "too large")
-				return format.Media{}, errors.Errorf("size %dMb too large", r.Size>>20)
+				return Media{}, errors.Errorf("size %dMb too large", r.Size>>20)
 			}
 		}
 	}
 
-	mimeType := r.MIMEType
-	if converter, ok := r.Manager.Converters[mimeType]; ok {
-		ref, err := converter.Convert(ctx, r)
-		if err != nil {
-			r.incrementMediaError(r.MIMEType, "convert")
-			return format.Media{}, errors.Wrapf(err, "convert from %s", mimeType)
-		}
-
-		return ref.Get(ctx)
+	if media, ok, err := r.convert(ctx); ok {
+		return media, err
 	}
 
+	mimeType := r.MIMEType
 	mediaType := telegram.MediaTypeByMIMEType(mimeType)
 	if mediaType == telegram.DefaultMediaType {
 		r.incrementMediaError(r.MIMEType, "mime")
-		return format.Media{}, errors.Errorf("unsupported mime type: %s", mimeType)
+		return Media{}, errors.Errorf("unsupported mime type: %s", mimeType)
 	}
 
 	if r.Size != UnknownSize && r.Size <= mediaType.RemoteMaxSize() && !r.Dedup && !r.Blob {
 		r.incrementMediaMethod(r.MIMEType, "remote")
-		return format.Media{
+		return r.deliver(ctx, format.Media{
 			MIMEType: mimeType,
 			Input:    flu.URL(r.ResolvedURL),
-		}, nil
+		}, r.MediaMetadata), nil
 	}
 
-	if r.Size == UnknownSize || r.Size <= mediaType.AttachMaxSize() {
+	if r.Size == UnknownSize || r.Size <= mediaType.AttachMaxSize() || r.canDownscale(mimeType, r.Size) {
 		blob, err := r.Manager.Storage.Alloc()
 		if err != nil {
-			return format.Media{}, errors.Wrap(err, "create blob")
+			return Media{}, errors.Wrap(err, "create blob")
 		}
 
-		counter := &flu.IOCounter{Output: blob}
-		if err := client.Contents(ctx, r.ResolvedURL, counter); err != nil {
+		size, err := r.Download(ctx, blob)
+		if errors.Is(err, ErrMediaTooLarge) {
+			r.incrementMediaError(r.MIMEType, "too large")
+			return Media{}, errors.Errorf("size exceeds %dMb", r.Manager.SizeBounds[1]>>20)
+		} else if err != nil {
 			r.incrementMediaError(r.MIMEType, "download")
-			return format.Media{}, errors.Wrap(err, "download")
+			return Media{}, errors.Wrap(err, "download")
+		}
+
+		// the declared type may be wrong, so check the contents before routing them further
+		if data, err := sniffInput(blob); err == nil {
+			if sniffed := r.sniffMIMEType(mimeType, data); sniffed != mimeType {
+				r.MIMEType, mimeType = sniffed, sniffed
+				if media, ok, err := r.convert(ctx); ok {
+					return media, err
+				}
+
+				mediaType = telegram.MediaTypeByMIMEType(mimeType)
+				if mediaType == telegram.DefaultMediaType {
+					r.incrementMediaError(r.MIMEType, "mime")
+					return Media{}, errors.Errorf("unsupported mime type: %s", mimeType)
+				}
+			}
 		}
 
-		if counter.Value() <= mediaType.AttachMaxSize() {
+		if size <= mediaType.AttachMaxSize() || r.canDownscale(mimeType, size) {
+			if r.Manager.FileIDs != nil {
+				if r.Hash == "" {
+					if r.Hash, err = contentHash(blob); err != nil {
+						return Media{}, errors.Wrap(err, "hash")
+					}
+				}
+
+				if !r.Dedup {
+					if media, ok := r.getFileID(ctx); ok {
+						return media, nil
+					}
+				}
+			}
+
+			var downscaled format.MediaRef
+			if r.canDownscale(mimeType, size) {
+				downscaled, err = r.Manager.Downscaler.Downscale(ctx, r, mimeType, blob, size)
+				if err != nil {
+					r.incrementMediaError(r.MIMEType, "downscale")
+					return Media{}, errors.Wrap(err, "downscale")
+				}
+			}
+
+			// the original blob is checked only once it is known to be deliverable
 			if r.Dedup {
 				if err := r.Manager.Dedup.Check(ctx, r.FeedID, r.URL, mimeType, blob); err != nil {
 					r.incrementMediaError(r.MIMEType, "dedup")
-					return format.Media{}, err
+					return Media{}, err
+				}
+
+				if r.Manager.FileIDs != nil {
+					if media, ok := r.getFileID(ctx); ok {
+						return media, nil
+					}
 				}
 			}
 
-			r.incrementMediaMethod(r.MIMEType, "attach")
-			return format.Media{
-				MIMEType: mimeType,
-				Input:    blob,
-			}, nil
+			if downscaled != nil {
+				r.incrementMediaMethod(r.MIMEType, "downscale")
+				return r.getTransformed(ctx, downscaled)
+			}
+
+			if size <= mediaType.AttachMaxSize() {
+				r.incrementMediaMethod(r.MIMEType, "attach")
+				return r.deliver(ctx, format.Media{
+					MIMEType: mimeType,
+					Input:    blob,
+				}, r.MediaMetadata), nil
+			}
 		}
 	}
 
 	r.incrementMediaError(r.MIMEType, "too large")
-	return format.Media{}, errors.Errorf("size %dMb is too large", r.Size>>20)
+	return Media{}, errors.Errorf("size %dMb is too large", r.Size>>20)
+}
+
+func (r *MediaRef) canDownscale(mimeType string, size int64) bool {
+	return r.Manager.Downscaler != nil && r.Manager.Downscaler.CanDownscale(mimeType, size)
+}
+
+// convert converts the media if there is a converter for its MIME type.
+// It returns false if the media does not need to be converted.
+func (r *MediaRef) convert(ctx context.Context) (Media, bool, error) {
+	converter, ok := r.Manager.Converters[r.MIMEType]
+	if !ok {
+		return Media{}, false, nil
+	}
+
+	var ref format.MediaRef
+	err := r.Manager.Breakers.Do(ctx, converter.Name(), func() (err error) {
+		ref, err = converter.Convert(ctx, r)
+		return
+	})
+
+	if errors.Is(err, ErrCircuitOpen) {
+		// conversion is skipped until the converter recovers
+		r.incrementMediaError(r.MIMEType, "circuit open")
+		return Media{}, false, nil
+	} else if err != nil {
+		r.incrementMediaError(r.MIMEType, "convert")
+		return Media{}, true, errors.Wrapf(err, "convert from %s", r.MIMEType)
+	}
+
+	// converter returns the same ref if no conversion is required
+	if ref == format.MediaRef(r) {
+		return Media{}, false, nil
+	}
+
+	media, err := r.getTransformed(ctx, ref)
+	return media, true, err
+}
+
+// getTransformed gets the converted or downscaled media.
+// Dimensions of the transformed media are probed again.
+func (r *MediaRef) getTransformed(ctx context.Context, ref format.MediaRef) (Media, error) {
+	if ref, ok := ref.(*MediaRef); ok {
+		return ref.getMedia(ctx)
+	}
+
+	media, err := ref.Get(ctx)
+	if err != nil {
+		return Media{}, err
+	}
+
+	return r.deliver(ctx, media, MediaMetadata{MIMEType: media.MIMEType, Duration: r.Duration}), nil
+}
+
+// deliver prepares the media for sending.
+// Video attributes are taken from metadata, and missing ones are filled in by Manager.Prober for attached videos.
+func (r *MediaRef) deliver(ctx context.Context, media format.Media, metadata MediaMetadata) Media {
+	switch telegram.MediaTypeByMIMEType(media.MIMEType) {
+	case telegram.Video, telegram.Animation:
+	default:
+		return Media{Media: media, ref: r}
+	}
+
+	thumbnail := r.Thumbnail
+	if blob, ok := media.Input.(format.Blob); ok && r.Manager.Prober != nil {
+		probed, err := r.Manager.Prober.Probe(ctx, blob, &metadata)
+		if err != nil {
+			log.Printf("[media > %s] failed to probe: %s", r.URL, err)
+		} else if thumbnail == nil {
+			thumbnail = probed
+		}
+	}
+
+	return Media{
+		Media: media,
+		Attributes: botapi.Attributes{
+			Width:     metadata.Width,
+			Height:    metadata.Height,
+			Duration:  metadata.Duration,
+			Thumbnail: thumbnail,
+		},
+		ref: r,
+	}
+}
+
+// getFileID returns media with file_id of the same media sent before, if any.
+// Manager.NoFileIDs lists feeds which are not Telegram chats, so file_ids are not used for them.
+func (r *MediaRef) getFileID(ctx context.Context) (Media, bool) {
+	storage := r.Manager.FileIDs
+	if storage == nil || r.Manager.NoFileIDs[r.FeedID] {
+		return Media{}, false
+	}
+
+	fileID, err := storage.GetFileID(ctx, r.URL, r.Hash)
+	if err != nil {
+		log.Printf("[media > %s] failed to get file id: %s", r.URL, err)
+		return Media{}, false
+	}
+
+	if fileID == nil {
+		return Media{}, false
+	}
+
+	r.incrementMediaMethod(fileID.MIMEType, "file_id")
+	return Media{Media: format.Media{
+		MIMEType: fileID.MIMEType,
+		Input:    flu.URL(fileID.FileID),
+	}}, true
 }
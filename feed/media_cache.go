@@ -0,0 +1,267 @@
+package feed
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/pkg/errors"
+)
+
+// MediaCache stores media contents by URL and content hash.
+type MediaCache interface {
+	// Get writes cached contents of url to out and returns the content hash and size.
+	// Empty hash is returned on cache miss.
+	Get(url string, out flu.Output) (hash string, size int64, err error)
+	// Put stores contents of url and returns the content hash.
+	Put(url string, in flu.Input) (hash string, err error)
+}
+
+// DefaultMediaCacheSize is the default FileMediaCache size limit.
+var DefaultMediaCacheSize int64 = 1 << 30
+
+const mediaCacheIndex = "urls"
+
+type mediaCacheEntry struct {
+	hash string
+	size int64
+}
+
+// FileMediaCache is a content-addressed MediaCache stored in Directory.
+// Contents are stored in files named by their SHA-256 hash and
+// least recently used ones are evicted when the total size exceeds MaxSize.
+// URLs are mapped to content hashes in an append-only index file.
+type FileMediaCache struct {
+	Directory string
+	MaxSize   int64
+	entries   map[string]*list.Element
+	lru       *list.List
+	urls      map[string]string
+	index     *os.File
+	size      int64
+	mu        sync.Mutex
+}
+
+func (c *FileMediaCache) Init() (*FileMediaCache, error) {
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultMediaCacheSize
+	}
+
+	if err := os.MkdirAll(c.Directory, 0755); err != nil {
+		return nil, errors.Wrap(err, "create directory")
+	}
+
+	files, err := ioutil.ReadDir(c.Directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "read directory")
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	c.entries = make(map[string]*list.Element)
+	c.lru = list.New()
+	for _, file := range files {
+		hash := file.Name()
+		if file.IsDir() || !isContentHash(hash) {
+			continue
+		}
+
+		c.entries[hash] = c.lru.PushBack(&mediaCacheEntry{hash: hash, size: file.Size()})
+		c.size += file.Size()
+	}
+
+	if err := c.loadIndex(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+	return c, nil
+}
+
+// loadIndex reads the URL index and rewrites it without evicted entries.
+func (c *FileMediaCache) loadIndex() error {
+	c.urls = make(map[string]string)
+	path := filepath.Join(c.Directory, mediaCacheIndex)
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.SplitN(scanner.Text(), " ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+
+			if _, ok := c.entries[fields[0]]; ok {
+				c.urls[fields[1]] = fields[0]
+			}
+		}
+
+		flu.Close(file)
+		if err := scanner.Err(); err != nil {
+			return errors.Wrap(err, "read index")
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "open index")
+	}
+
+	temp := path + ".tmp"
+	index, err := os.Create(temp)
+	if err != nil {
+		return errors.Wrap(err, "create index")
+	}
+
+	for url, hash := range c.urls {
+		if _, err := fmt.Fprintf(index, "%s %s\n", hash, url); err != nil {
+			flu.Close(index)
+			return errors.Wrap(err, "write index")
+		}
+	}
+
+	flu.Close(index)
+	if err := os.Rename(temp, path); err != nil {
+		return errors.Wrap(err, "replace index")
+	}
+
+	c.index, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open index")
+	}
+
+	return nil
+}
+
+func (c *FileMediaCache) Get(url string, out flu.Output) (string, int64, error) {
+	c.mu.Lock()
+	hash := c.urls[url]
+	element, ok := c.entries[hash]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+
+	c.mu.Unlock()
+	if !ok {
+		return "", 0, nil
+	}
+
+	path := c.path(hash)
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	counter := &flu.IOCounter{Output: out}
+	if err := flu.Copy(flu.File(path), counter); err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			// evicted concurrently
+			return "", 0, nil
+		}
+
+		return "", 0, errors.Wrap(err, "read cached contents")
+	}
+
+	return hash, counter.Value(), nil
+}
+
+func (c *FileMediaCache) Put(url string, in flu.Input) (string, error) {
+	reader, err := in.Reader()
+	if err != nil {
+		return "", errors.Wrap(err, "read")
+	}
+
+	defer flu.Close(reader)
+	temp, err := ioutil.TempFile(c.Directory, "put-*.tmp")
+	if err != nil {
+		return "", errors.Wrap(err, "create temp file")
+	}
+
+	defer os.Remove(temp.Name())
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(temp, hasher), reader)
+	flu.Close(temp)
+	if err != nil {
+		return "", errors.Wrap(err, "write contents")
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[hash]; ok {
+		c.lru.MoveToFront(element)
+	} else {
+		if err := os.Rename(temp.Name(), c.path(hash)); err != nil {
+			return "", errors.Wrap(err, "store contents")
+		}
+
+		c.entries[hash] = c.lru.PushFront(&mediaCacheEntry{hash: hash, size: size})
+		c.size += size
+	}
+
+	if c.urls[url] != hash {
+		c.urls[url] = hash
+		if _, err := fmt.Fprintf(c.index, "%s %s\n", hash, url); err != nil {
+			log.Printf("[media cache] failed to write index: %s", err)
+		}
+	}
+
+	c.evict()
+	return hash, nil
+}
+
+func (c *FileMediaCache) evict() {
+	for c.size > c.MaxSize && c.lru.Len() > 0 {
+		entry := c.lru.Remove(c.lru.Back()).(*mediaCacheEntry)
+		delete(c.entries, entry.hash)
+		c.size -= entry.size
+		if err := os.Remove(c.path(entry.hash)); err != nil && !os.IsNotExist(err) {
+			log.Printf("[media cache] failed to remove %s: %s", entry.hash, err)
+		}
+	}
+}
+
+func (c *FileMediaCache) path(hash string) string {
+	return filepath.Join(c.Directory, hash)
+}
+
+func (c *FileMediaCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.index != nil {
+		return c.index.Close()
+	}
+
+	return nil
+}
+
+func isContentHash(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// contentHash returns hex-encoded SHA-256 hash of the input contents.
+func contentHash(in flu.Input) (string, error) {
+	reader, err := in.Reader()
+	if err != nil {
+		return "", errors.Wrap(err, "read")
+	}
+
+	defer flu.Close(reader)
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", errors.Wrap(err, "hash")
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,60 @@
+package feed_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMediaCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hikkabot-cache-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := (&feed.FileMediaCache{Directory: dir, MaxSize: 10}).Init()
+	assert.Nil(t, err)
+
+	buf := flu.NewBuffer()
+	hash, _, err := cache.Get("a", buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "", hash)
+
+	hashA, err := cache.Put("a", flu.Bytes("aaaa"))
+	assert.Nil(t, err)
+	hashB, err := cache.Put("b", flu.Bytes("aaaa"))
+	assert.Nil(t, err)
+	assert.Equal(t, hashA, hashB)
+
+	_, err = cache.Put("c", flu.Bytes("cccc"))
+	assert.Nil(t, err)
+
+	hash, size, err := cache.Get("b", buf)
+	assert.Nil(t, err)
+	assert.Equal(t, hashA, hash)
+	assert.Equal(t, int64(4), size)
+	assert.Equal(t, "aaaa", string(buf.Bytes()))
+
+	// "c" is evicted as the least recently used one
+	_, err = cache.Put("d", flu.Bytes("dddd"))
+	assert.Nil(t, err)
+	hash, _, err = cache.Get("c", buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "", hash)
+	assert.Nil(t, cache.Close())
+
+	cache, err = (&feed.FileMediaCache{Directory: dir, MaxSize: 10}).Init()
+	assert.Nil(t, err)
+	defer cache.Close()
+
+	hash, _, err = cache.Get("a", buf)
+	assert.Nil(t, err)
+	assert.Equal(t, hashA, hash)
+	assert.Equal(t, "aaaa", string(buf.Bytes()))
+	hash, _, err = cache.Get("d", buf)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", hash)
+}
@@ -0,0 +1,243 @@
+package feed
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/pkg/errors"
+)
+
+// MediaCandidate is a resolved version of the media.
+// Zero Size means that the size is unknown, and empty MIMEType means that the metadata is requested before download.
+type MediaCandidate struct {
+	URL string
+	MediaMetadata
+	// Quality ranks versions of the same media, higher is better.
+	Quality int
+	// EstimatedSize is an approximate size which is used for ranking only when Size is unknown.
+	EstimatedSize int64
+	// Headers are sent with requests for URL. Optional.
+	Headers map[string]string
+}
+
+// MediaResolution is the result of resolving a media URL.
+// It contains either versions of the same media or media of an album.
+type MediaResolution struct {
+	Candidates []MediaCandidate
+	// Album contains candidates of each album media.
+	Album [][]MediaCandidate
+}
+
+type (
+	// MediaCandidateResolver resolves a URL to several versions of the media or to album media.
+	// It is used instead of MediaResolver.ResolveURL when implemented by the resolver.
+	MediaCandidateResolver interface {
+		Resolve(ctx context.Context, client *fluhttp.Client, url string) (*MediaResolution, error)
+	}
+
+	// MediaPageResolver resolves a web page to candidates of the media it embeds.
+	// It is used for media URLs which turn out to point to an HTML page, and the candidates are tried in order.
+	MediaPageResolver interface {
+		ResolvePage(ctx context.Context, client *fluhttp.Client, url string) ([]MediaCandidate, error)
+	}
+
+	// MediaAlbumResolver checks if the URL points to an album without making requests.
+	// Albums are resolved when the media is submitted in order to be expanded into several media.
+	MediaAlbumResolver interface {
+		MediaCandidateResolver
+		IsAlbum(url string) bool
+	}
+)
+
+// SubmitAll submits all media of the album the reference points to.
+// Only the first AlbumLimit album media are submitted if AlbumLimit is positive.
+// The reference itself is submitted if it does not point to an album or the album can not be resolved.
+func (m *MediaManager) SubmitAll(ctx context.Context, ref *MediaRef) []format.MediaRef {
+	m.setup(ref)
+	if resolver, ok := ref.MediaResolver.(MediaAlbumResolver); ok && resolver.IsAlbum(ref.URL) {
+		var resolution *MediaResolution
+		err := m.Breakers.Do(ctx, ref.ResolverName, func() (err error) {
+			resolution, err = resolver.Resolve(ctx, ref.getClient(), ref.URL)
+			return
+		})
+
+		switch {
+		case err != nil:
+			log.Printf("[media > %s] failed to resolve album: %s", ref.URL, err)
+		case len(resolution.Album) > 0:
+			refs := make([]format.MediaRef, 0, len(resolution.Album))
+			for _, candidates := range resolution.Album {
+				if len(candidates) == 0 {
+					continue
+				}
+
+				if m.AlbumLimit > 0 && len(refs) >= m.AlbumLimit {
+					log.Printf("[media > %s] album has more than %d media, the rest is skipped", ref.URL, m.AlbumLimit)
+					break
+				}
+
+				// the first candidate identifies the media
+				refs = append(refs, m.Submit(&MediaRef{
+					MediaResolver: DummyMediaResolver{Client: ref.GetClient()},
+					URL:           candidates[0].URL,
+					Dedup:         ref.Dedup,
+					Blob:          ref.Blob,
+					FeedID:        ref.FeedID,
+					candidates:    candidates,
+				}))
+			}
+
+			if len(refs) > 0 {
+				return refs
+			}
+		case len(resolution.Candidates) > 0:
+			ref.candidates = resolution.Candidates
+		}
+	}
+
+	return []format.MediaRef{m.Submit(ref)}
+}
+
+// resolve returns media candidates in the order of preference.
+func (r *MediaRef) resolve(ctx context.Context) ([]MediaCandidate, error) {
+	candidates := r.candidates
+	if candidates == nil {
+		if resolver, ok := r.MediaResolver.(MediaCandidateResolver); ok {
+			var resolution *MediaResolution
+			if err := r.Manager.Breakers.Do(ctx, r.ResolverName, func() (err error) {
+				resolution, err = resolver.Resolve(ctx, r.getClient(), r.URL)
+				return
+			}); err != nil {
+				return nil, err
+			}
+
+			candidates = resolution.Candidates
+			if len(resolution.Album) > 0 {
+				log.Printf("[media > %s] album has not been expanded, only the first media is used", r.URL)
+				candidates = resolution.Album[0]
+			}
+		} else {
+			var url string
+			if err := r.Manager.Breakers.Do(ctx, r.ResolverName, func() (err error) {
+				url, err = r.ResolveURL(ctx, r.getClient(), r.URL, telegram.Video.AttachMaxSize())
+				return
+			}); err != nil {
+				return nil, err
+			}
+
+			// resolvers may set media metadata
+			candidates = []MediaCandidate{{URL: url, MediaMetadata: r.MediaMetadata, Headers: r.Headers}}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.New("no media found")
+	}
+
+	candidates = append([]MediaCandidate{}, candidates...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].better(candidates[j])
+	})
+
+	return candidates, nil
+}
+
+// pageCircuit is the name of Manager.Breakers circuit for Manager.PageResolver.
+const pageCircuit = "page"
+
+// isPage checks if the media has turned out to be a web page which should be resolved with Manager.PageResolver.
+// Pages are not resolved recursively.
+func (r *MediaRef) isPage(mimeType string) bool {
+	return mimeType == "text/html" && r.Manager.PageResolver != nil && !r.page
+}
+
+// getPage gets the media embedded into the web page at ResolvedURL.
+// Page resolution is tracked by Manager.Breakers as a single circuit
+// since pages are resolved by a single resolver and may come from any host.
+func (r *MediaRef) getPage(ctx context.Context) (Media, error) {
+	r.page = true
+	var candidates []MediaCandidate
+	if err := r.Manager.Breakers.Do(ctx, pageCircuit, func() (err error) {
+		candidates, err = r.Manager.PageResolver.ResolvePage(ctx, r.getClient(), r.ResolvedURL)
+		return
+	}); err != nil {
+		r.incrementMediaError("text/html", "page")
+		return Media{}, errors.Wrap(err, "resolve page")
+	}
+
+	if len(candidates) == 0 {
+		r.incrementMediaError("text/html", "page")
+		return Media{}, errors.New("no media found on page")
+	}
+
+	return r.getCandidates(ctx, candidates)
+}
+
+// fitness is 0 for candidates which fit Telegram limits, 1 for candidates of unknown size and 2 for others.
+// Candidates of unknown size are considered too large if their estimated size exceeds the limits.
+func (c MediaCandidate) fitness() int {
+	size := c.Size
+	if size <= 0 {
+		size = c.EstimatedSize
+	}
+
+	if size <= 0 {
+		return 1
+	}
+
+	mediaType := telegram.MediaTypeByMIMEType(c.MIMEType)
+	if mediaType == telegram.DefaultMediaType {
+		// this is most probably a video which is going to be converted
+		mediaType = telegram.Video
+	}
+
+	switch {
+	case size > mediaType.AttachMaxSize():
+		return 2
+	case c.Size <= 0:
+		// approximate sizes can not be relied on
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (c MediaCandidate) better(other MediaCandidate) bool {
+	if fitness, otherFitness := c.fitness(), other.fitness(); fitness != otherFitness {
+		return fitness < otherFitness
+	}
+
+	if c.Quality != other.Quality {
+		return c.Quality > other.Quality
+	}
+
+	return c.Size > other.Size
+}
+
+// use sets the candidate as the resolved media.
+func (r *MediaRef) use(candidate MediaCandidate, cacheByURL bool) {
+	r.ResolvedURL = candidate.URL
+	r.Headers = candidate.Headers
+	r.MIMEType, r.Size = candidate.MIMEType, candidate.Size
+	if r.MIMEType != "" && r.Size == 0 {
+		r.Size = UnknownSize
+	}
+
+	if candidate.Width > 0 && candidate.Height > 0 {
+		r.Width, r.Height = candidate.Width, candidate.Height
+	}
+
+	if candidate.Duration > 0 {
+		r.Duration = candidate.Duration
+	}
+
+	r.Hash = ""
+	r.cacheKey = ""
+	if cacheByURL {
+		r.cacheKey = candidate.URL
+	}
+}
@@ -28,6 +28,10 @@ const (
 type MediaMetadata struct {
 	Size     int64
 	MIMEType string
+	// Width, Height and Duration (in seconds) are optional and used for videos.
+	Width    int
+	Height   int
+	Duration int
 }
 
 func (m *MediaMetadata) Handle(resp *http.Response) error {
@@ -55,11 +59,15 @@ type MediaClient interface {
 }
 
 type DefaultMediaClient struct {
-	main, fallback MediaClient
-	retries        int
+	main     StdLibClient
+	fallback MediaClient
+	retries  int
+	maxSize  int64
 }
 
-func NewMediaClient(client *fluhttp.Client, curl string, retries int) DefaultMediaClient {
+// NewMediaClient creates a MediaClient which retries failed requests and resumes interrupted downloads.
+// Downloads exceeding maxSize are aborted with ErrMediaTooLarge. Zero maxSize means no limit.
+func NewMediaClient(client *fluhttp.Client, curl string, retries int, maxSize int64) DefaultMediaClient {
 	main := StdLibClient{client}
 	var fallback MediaClient
 	if curl != "" {
@@ -67,7 +75,7 @@ func NewMediaClient(client *fluhttp.Client, curl string, retries int) DefaultMed
 	} else {
 		fallback = main
 	}
-	return DefaultMediaClient{main, fallback, retries}
+	return DefaultMediaClient{main, fallback, retries, maxSize}
 }
 
 func (c DefaultMediaClient) Metadata(ctx context.Context, url string) (*MediaMetadata, error) {
@@ -80,15 +88,30 @@ func (c DefaultMediaClient) Metadata(ctx context.Context, url string) (*MediaMet
 }
 
 func (c DefaultMediaClient) Contents(ctx context.Context, url string, out flu.Output) error {
-	return c.retry(ctx, url, "download", func(client MediaClient) error {
-		return client.Contents(ctx, url, out)
+	_, err := c.Download(ctx, url, out)
+	return err
+}
+
+// Download writes contents to out and returns the amount of bytes written.
+// Retries continue the download where it stopped if possible.
+func (c DefaultMediaClient) Download(ctx context.Context, url string, out flu.Output) (int64, error) {
+	d := &download{out: out, maxSize: c.maxSize}
+	defer d.close()
+	err := c.retry(ctx, url, "download", func(client MediaClient) error {
+		if client, ok := client.(StdLibClient); ok {
+			return client.resume(ctx, url, d)
+		}
+
+		return client.Contents(ctx, url, d)
 	})
+
+	return d.written, err
 }
 
 func (c DefaultMediaClient) retry(ctx context.Context, url string, op string, body func(MediaClient) error) error {
 	var client MediaClient = c.main
 	if err := body(client); err != nil {
-		for i := 0; i < c.retries; i++ {
+		for i := 0; i < c.retries && !isPermanent(op, err); i++ {
 			log.Printf("[media > %s] %s (retry %d): %s", url, op, i, err)
 			if !IsNetworkError(err) || i == 3 {
 				client = c.fallback
@@ -115,6 +138,13 @@ func (c DefaultMediaClient) retry(ctx context.Context, url string, op string, bo
 	return nil
 }
 
+// isPermanent checks if the error can not be fixed by retrying.
+// Refused HEAD requests are not retried since the contents are sniffed instead.
+func isPermanent(op string, err error) bool {
+	var status fluhttp.StatusCodeError
+	return errors.Is(err, ErrMediaTooLarge) || op == "head" && errors.As(err, &status)
+}
+
 func IsNetworkError(err error) bool {
 	for {
 		if _, ok := err.(*net.OpError); ok {
@@ -127,6 +157,36 @@ func IsNetworkError(err error) bool {
 	}
 }
 
+// withHeaders returns a copy of the client which adds the headers to every request unless they are set already.
+func withHeaders(client *fluhttp.Client, headers map[string]string) *fluhttp.Client {
+	httpClient := *client.Client
+	httpClient.Transport = headerTransport{headers: headers, transport: httpClient.Transport}
+	clone := *client
+	clone.Client = &httpClient
+	return &clone
+}
+
+type headerTransport struct {
+	headers   map[string]string
+	transport http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, value := range t.headers {
+		if req.Header.Get(key) == "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return transport.RoundTrip(req)
+}
+
 type StdLibClient struct {
 	*fluhttp.Client
 }
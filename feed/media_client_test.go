@@ -0,0 +1,105 @@
+package feed_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultMediaClient_Resume(t *testing.T) {
+	contents := []byte(strings.Repeat("0123456789", 1000))
+	ranges := make([]string, 0)
+	agents := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		agents = append(agents, r.Header.Get("User-Agent"))
+		w.Header().Set("ETag", `"v1"`)
+		if len(ranges) == 1 {
+			// fail in the middle of the first response
+			w.Header().Set("Content-Length", "10000")
+			_, _ = w.Write(contents[:4000])
+			panic(http.ErrAbortHandler)
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "hikkabot-download-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out")
+	client := feed.NewMediaClient(fluhttp.NewClient(nil).SetHeader("User-Agent", "test"), "", 3, 0)
+	size, err := client.Download(context.Background(), server.URL, flu.File(path))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(contents)), size)
+	assert.Equal(t, []string{"", "bytes=4000-"}, ranges)
+	// client headers are sent with ranged requests
+	assert.Equal(t, []string{"test", "test"}, agents)
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, contents, data)
+}
+
+func TestDefaultMediaClient_SizeGuard(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Transfer-Encoding", "chunked")
+		_, _ = w.Write(make([]byte, 2048))
+	}))
+	defer server.Close()
+
+	client := feed.NewMediaClient(fluhttp.NewClient(nil), "", 3, 1024)
+	_, err := client.Download(context.Background(), server.URL, flu.IO{W: ioutil.Discard})
+	assert.True(t, errors.Is(err, feed.ErrMediaTooLarge))
+	assert.Equal(t, 1, requests)
+}
+
+func TestDefaultMediaClient_Sniff(t *testing.T) {
+	contents := append([]byte("GIF89a"), make([]byte, 2000)...)
+	methods := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Header.Get("User-Agent") != "test" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer server.Close()
+
+	client := feed.NewMediaClient(fluhttp.NewClient(nil).SetHeader("User-Agent", "test"), "", 3, 0)
+	_, err := client.Metadata(context.Background(), server.URL)
+	assert.NotNil(t, err)
+
+	metadata, data, err := client.Sniff(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(contents)), metadata.Size)
+	assert.Equal(t, "application/octet-stream", metadata.MIMEType)
+	assert.Equal(t, feed.SniffSize, len(data))
+	assert.Equal(t, "image/gif", feed.SniffMIMEType(data))
+	assert.Equal(t, []string{http.MethodHead, http.MethodGet}, methods)
+}
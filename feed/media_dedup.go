@@ -5,21 +5,41 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"image"
+	"image/draw"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/corona10/goimagehash"
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/vendors/common"
 	"github.com/pkg/errors"
 	"golang.org/x/image/bmp"
 )
 
+// DefaultDedupFrames is the default amount of frames hashed for videos and animations.
+var DefaultDedupFrames = 5
+
 type ReadImageFunc func(io.Reader) (image.Image, error)
 
 type DefaultMediaDedup struct {
 	BlobStorage BlobStorage
+	// Scopes resolves dedup scopes of feeds. Each feed is deduplicated on its own if it is not set.
+	Scopes DedupScopeStorage
+	// FFmpeg is the path to ffmpeg binary used for video frame extraction.
+	// Videos are hashed with md5 if it is not set.
+	FFmpeg string
+	// Frames is the maximum amount of frames hashed for videos and animations.
+	Frames int
 }
 
 func (d DefaultMediaDedup) Check(ctx context.Context, feedID ID, url, mimeType string, blob format.Blob) error {
@@ -45,12 +65,25 @@ func (d DefaultMediaDedup) Check(ctx context.Context, feedID ID, url, mimeType s
 		readImage = bmp.Decode
 	}
 
-	if readImage != nil {
+	switch {
+	case readImage != nil:
 		hashType, hash, err = d.hashImage(readImage, reader)
 		if err != nil {
 			return err
 		}
-	} else {
+	case mimeType == "image/gif":
+		hashType, hash, err = d.hashGIF(reader)
+		if err != nil {
+			log.Printf("[media > %s] failed to hash gif frames, falling back to md5: %s", url, err)
+		}
+	case strings.HasPrefix(mimeType, "video/") && d.FFmpeg != "":
+		hashType, hash, err = d.hashVideo(ctx, reader)
+		if err != nil {
+			log.Printf("[media > %s] failed to hash video frames, falling back to md5: %s", url, err)
+		}
+	}
+
+	if hash == nil {
 		hashType = "md5"
 		md5Hash := md5.New()
 		if err := flu.Copy(blob, flu.IO{W: md5Hash}); err != nil {
@@ -60,7 +93,82 @@ func (d DefaultMediaDedup) Check(ctx context.Context, feedID ID, url, mimeType s
 		hash = md5Hash.Sum(nil)
 	}
 
-	return d.BlobStorage.CheckBlob(ctx, feedID, url, hashType, hash)
+	return d.checkBlob(ctx, feedID, url, hashType, hash)
+}
+
+// MinTextTokens is the minimum amount of words in text for it to be checked for duplicates.
+var MinTextTokens = 3
+
+// CheckText looks up texts similar to the one passed without recording it.
+func (d DefaultMediaDedup) CheckText(ctx context.Context, feedID ID, url, text string) error {
+	hash := textHash(text)
+	if hash == nil {
+		return nil
+	}
+
+	scope, err := d.scope(ctx, feedID)
+	if err != nil {
+		return err
+	}
+
+	oldURL, err := d.BlobStorage.FindBlob(ctx, scope, feedID, url, "simhash", hash)
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "find blob")
+	case oldURL == "":
+		return nil
+	case oldURL != url:
+		return errors.Wrapf(format.ErrSkipMedia, "duplicates %s", oldURL)
+	default:
+		return errors.Wrap(format.ErrSkipMedia, "duplicate")
+	}
+}
+
+// RecordText records the text as sent to the feed.
+func (d DefaultMediaDedup) RecordText(ctx context.Context, feedID ID, url, text string) error {
+	hash := textHash(text)
+	if hash == nil {
+		return nil
+	}
+
+	if err := d.checkBlob(ctx, feedID, url, "simhash", hash); err != nil && !errors.Is(err, format.ErrSkipMedia) {
+		return err
+	}
+
+	return nil
+}
+
+func textHash(text string) []byte {
+	tokens := common.TextTokens(text)
+	if len(tokens) < MinTextTokens {
+		return nil
+	}
+
+	hash := make([]byte, 8)
+	binary.LittleEndian.PutUint64(hash, common.SimHash(tokens))
+	return hash
+}
+
+func (d DefaultMediaDedup) scope(ctx context.Context, feedID ID) (string, error) {
+	if d.Scopes != nil {
+		scope, err := d.Scopes.GetDedupScope(ctx, feedID)
+		if err != nil {
+			return "", errors.Wrap(err, "get dedup scope")
+		}
+
+		return scope, nil
+	}
+
+	return ChatDedupScope(feedID), nil
+}
+
+func (d DefaultMediaDedup) checkBlob(ctx context.Context, feedID ID, url, hashType string, hash []byte) error {
+	scope, err := d.scope(ctx, feedID)
+	if err != nil {
+		return err
+	}
+
+	return d.BlobStorage.CheckBlob(ctx, scope, feedID, url, hashType, hash)
 }
 
 func (d DefaultMediaDedup) hashImage(readImage ReadImageFunc, reader io.Reader) (string, []byte, error) {
@@ -69,12 +177,135 @@ func (d DefaultMediaDedup) hashImage(readImage ReadImageFunc, reader io.Reader)
 		return "", nil, errors.Wrap(err, "read image")
 	}
 
+	hash, err := differenceHash(img)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "dhash", hash, nil
+}
+
+// hashGIF composes animation frames and hashes at most Frames of them evenly spread over the animation.
+// Single-frame GIFs are hashed as regular images.
+func (d DefaultMediaDedup) hashGIF(reader io.Reader) (string, []byte, error) {
+	anim, err := gif.DecodeAll(reader)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "read gif")
+	}
+
+	if len(anim.Image) == 1 {
+		hash, err := differenceHash(anim.Image[0])
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "dhash", hash, nil
+	}
+
+	frames := d.frames()
+	step := (len(anim.Image) + frames - 1) / frames
+	canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	hash := make([]byte, 0, frames*8)
+	for i, frame := range anim.Image {
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i%step != 0 {
+			continue
+		}
+
+		frameHash, err := differenceHash(canvas)
+		if err != nil {
+			return "", nil, err
+		}
+
+		hash = append(hash, frameHash...)
+	}
+
+	return "vdhash", hash, nil
+}
+
+// hashVideo extracts one frame per second from the beginning of the video with ffmpeg
+// and hashes at most Frames of them. Sampling by time makes the hash resistant to re-encoding.
+func (d DefaultMediaDedup) hashVideo(ctx context.Context, reader io.Reader) (string, []byte, error) {
+	dir, err := ioutil.TempDir("", "hikkabot-dedup")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create temp dir")
+	}
+
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input")
+	file, err := os.Create(input)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create input file")
+	}
+
+	_, err = io.Copy(file, reader)
+	flu.Close(file)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "write input file")
+	}
+
+	cmd := exec.CommandContext(ctx, d.FFmpeg,
+		"-v", "error",
+		"-i", input,
+		"-vf", "fps=1",
+		"-frames:v", strconv.Itoa(d.frames()),
+		filepath.Join(dir, "frame%03d.png"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", nil, errors.Wrapf(err, "run ffmpeg: %s", strings.TrimSpace(string(output)))
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "frame*.png"))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "list frames")
+	}
+
+	if len(paths) == 0 {
+		return "", nil, errors.New("no frames extracted")
+	}
+
+	hash := make([]byte, 0, len(paths)*8)
+	for _, path := range paths {
+		frameHash, err := hashImageFile(path)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "frame %s", filepath.Base(path))
+		}
+
+		hash = append(hash, frameHash...)
+	}
+
+	return "vdhash", hash, nil
+}
+
+func (d DefaultMediaDedup) frames() int {
+	if d.Frames > 0 {
+		return d.Frames
+	}
+
+	return DefaultDedupFrames
+}
+
+func hashImageFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+
+	defer flu.Close(file)
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read image")
+	}
+
+	return differenceHash(img)
+}
+
+func differenceHash(img image.Image) ([]byte, error) {
 	hash, err := goimagehash.DifferenceHash(img)
 	if err != nil {
-		return "", nil, errors.Wrap(err, "compute image hash")
+		return nil, errors.Wrap(err, "compute image hash")
 	}
 
 	buf := make([]byte, hash.Bits()/8)
 	binary.LittleEndian.PutUint64(buf, hash.GetHash())
-	return "dhash", buf, nil
+	return buf, nil
 }
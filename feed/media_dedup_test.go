@@ -0,0 +1,90 @@
+package feed_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+type hashTypeRecorder []string
+
+func (r *hashTypeRecorder) CheckBlob(_ context.Context, _ string, _ feed.ID, _ string, hashType string, _ []byte) error {
+	*r = append(*r, hashType)
+	return nil
+}
+
+func (r *hashTypeRecorder) FindBlob(_ context.Context, _ string, _ feed.ID, _ string, _ string, _ []byte) (string, error) {
+	return "", nil
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) flu.File {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+	return flu.File(path)
+}
+
+func testGIF(t *testing.T, frames int) []byte {
+	anim := &gif.GIF{Config: image.Config{Width: 16, Height: 16}}
+	palette := color.Palette{color.Black, color.White}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 16, 16), palette)
+		frame.Set(i, i, color.White)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, gif.EncodeAll(buf, anim))
+	return buf.Bytes()
+}
+
+func TestDefaultMediaDedup_GIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hikkabot-dedup-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	hashTypes := new(hashTypeRecorder)
+	dedup := feed.DefaultMediaDedup{BlobStorage: hashTypes}
+
+	assert.Nil(t, dedup.Check(ctx, 1, "a", "image/gif", writeTestFile(t, dir, "anim.gif", testGIF(t, 8))))
+	assert.Nil(t, dedup.Check(ctx, 1, "b", "image/gif", writeTestFile(t, dir, "still.gif", testGIF(t, 1))))
+	// broken GIFs are hashed with md5
+	assert.Nil(t, dedup.Check(ctx, 1, "c", "image/gif", writeTestFile(t, dir, "broken.gif", []byte("GIF89a broken"))))
+	assert.Equal(t, hashTypeRecorder{"vdhash", "dhash", "md5"}, *hashTypes)
+}
+
+func TestDefaultMediaDedup_Video(t *testing.T) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "hikkabot-dedup-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	video := filepath.Join(dir, "video.mp4")
+	out, err := exec.Command(ffmpeg, "-v", "error", "-f", "lavfi", "-i", "testsrc=duration=3:size=64x64:rate=10",
+		"-pix_fmt", "yuv420p", video).CombinedOutput()
+	assert.Nil(t, err, string(out))
+
+	ctx := context.Background()
+	hashTypes := new(hashTypeRecorder)
+	dedup := feed.DefaultMediaDedup{BlobStorage: hashTypes, FFmpeg: ffmpeg}
+	assert.Nil(t, dedup.Check(ctx, 1, "a", "video/mp4", flu.File(video)))
+	// videos which can not be decoded are hashed with md5
+	assert.Nil(t, dedup.Check(ctx, 1, "b", "video/mp4", writeTestFile(t, dir, "broken.mp4", []byte("not a video"))))
+	assert.Equal(t, hashTypeRecorder{"vdhash", "md5"}, *hashTypes)
+}
@@ -0,0 +1,155 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/pkg/errors"
+)
+
+// ErrMediaTooLarge is returned when media contents exceed the maximum size during download.
+var ErrMediaTooLarge = errors.New("media is too large")
+
+// download is the state of a download which may be resumed after failure.
+// It writes to the same output across retries and starts over only if resuming is not possible.
+type download struct {
+	out     flu.Output
+	w       io.Writer
+	written int64
+	maxSize int64
+	etag    string
+	total   int64
+}
+
+// Writer starts the download over.
+func (d *download) Writer() (io.Writer, error) {
+	if err := d.restart(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *download) restart() error {
+	d.close()
+	w, err := d.out.Writer()
+	if err != nil {
+		return errors.Wrap(err, "write")
+	}
+
+	d.w = w
+	d.written = 0
+	d.etag = ""
+	d.total = UnknownSize
+	return nil
+}
+
+func (d *download) Write(data []byte) (int, error) {
+	if d.maxSize > 0 && d.written+int64(len(data)) > d.maxSize {
+		return 0, ErrMediaTooLarge
+	}
+
+	n, err := d.w.Write(data)
+	d.written += int64(n)
+	return n, err
+}
+
+func (d *download) close() {
+	if d.w != nil {
+		flu.Close(d.w)
+		d.w = nil
+	}
+}
+
+// resume continues the download from the last written byte with a Range request.
+// The response is validated against ETag and total length of the previous one.
+func (s StdLibClient) resume(ctx context.Context, url string, d *download) error {
+	req := s.GET(url).Context(ctx)
+	if d.w != nil && d.written > 0 {
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-", d.written))
+		if d.etag != "" {
+			req.SetHeader("If-Range", d.etag)
+		}
+	}
+
+	return req.Execute().
+		CheckStatus(http.StatusOK, http.StatusPartialContent).
+		HandleResponse(d).
+		Error
+}
+
+func (d *download) Handle(resp *http.Response) error {
+	defer flu.Close(resp.Body)
+	if resp.StatusCode == http.StatusPartialContent {
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		etag := resp.Header.Get("ETag")
+		if !ok || start != d.written || total != d.total || etag != d.etag {
+			// the contents have changed, so start over on next attempt
+			if err := d.restart(); err != nil {
+				return err
+			}
+
+			return errors.New("range response mismatch")
+		}
+	} else {
+		if err := d.restart(); err != nil {
+			return err
+		}
+
+		d.etag = resp.Header.Get("ETag")
+		d.total = resp.ContentLength
+	}
+
+	if d.maxSize > 0 && d.total > d.maxSize {
+		return ErrMediaTooLarge
+	}
+
+	if _, err := io.Copy(d, resp.Body); err != nil {
+		return err
+	}
+
+	if d.total >= 0 && d.written != d.total {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// parseContentRange parses "bytes start-end/total" header value.
+// Unknown total is returned as UnknownSize.
+func parseContentRange(value string) (start, total int64, ok bool) {
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, false
+	}
+
+	parts := strings.SplitN(value[6:], "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	dash := strings.Index(parts[0], "-")
+	if dash < 0 {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(parts[0][:dash], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if parts[1] == "*" {
+		return start, UnknownSize, true
+	}
+
+	total, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return start, total, true
+}
@@ -0,0 +1,130 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/metrics"
+	"github.com/pkg/errors"
+)
+
+// SniffSize is the amount of leading bytes used to detect the media type.
+const SniffSize = 512
+
+// SniffMIMEType detects media MIME type by magic bytes.
+// Empty string is returned if data is not recognized as image, video or audio.
+func SniffMIMEType(data []byte) string {
+	if len(data) > SniffSize {
+		data = data[:SniffSize]
+	}
+
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || !isMediaMIMEType(mimeType) {
+		return ""
+	}
+
+	return mimeType
+}
+
+// isMediaMIMEType checks if the MIME type can be trusted to describe media contents.
+// Generic types like application/octet-stream are not.
+func isMediaMIMEType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") ||
+		strings.HasPrefix(mimeType, "video/") ||
+		strings.HasPrefix(mimeType, "audio/")
+}
+
+// sniffInput reads the leading bytes of the input.
+func sniffInput(in flu.Input) ([]byte, error) {
+	reader, err := in.Reader()
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	defer flu.Close(reader)
+	return ioutil.ReadAll(io.LimitReader(reader, SniffSize))
+}
+
+// Sniff requests leading bytes of the contents with a ranged GET.
+// It is used when HEAD is refused or the declared MIME type is too generic.
+// The returned metadata is filled from response headers and may lack MIME type.
+func (c DefaultMediaClient) Sniff(ctx context.Context, url string) (*MediaMetadata, []byte, error) {
+	var (
+		metadata *MediaMetadata
+		data     []byte
+	)
+
+	err := c.retry(ctx, url, "sniff", func(MediaClient) error {
+		var err error
+		metadata, data, err = c.main.sniff(ctx, url)
+		return err
+	})
+
+	return metadata, data, err
+}
+
+func (s StdLibClient) sniff(ctx context.Context, url string) (*MediaMetadata, []byte, error) {
+	sniffed := new(sniffedMedia)
+	if err := s.GET(url).Context(ctx).
+		SetHeader("Range", fmt.Sprintf("bytes=0-%d", SniffSize-1)).
+		Execute().
+		CheckStatus(http.StatusOK, http.StatusPartialContent).
+		HandleResponse(sniffed).
+		Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &sniffed.MediaMetadata, sniffed.data, nil
+}
+
+// sniffedMedia is the metadata and leading bytes of a ranged GET response.
+type sniffedMedia struct {
+	MediaMetadata
+	data []byte
+}
+
+func (m *sniffedMedia) Handle(resp *http.Response) error {
+	defer flu.Close(resp.Body)
+	m.Size = UnknownSize
+	m.MIMEType, _, _ = mime.ParseMediaType(resp.Header.Get(ContentTypeHeader))
+	if resp.StatusCode == http.StatusPartialContent {
+		if _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok {
+			m.Size = total
+		}
+	} else if resp.ContentLength >= 0 {
+		m.Size = resp.ContentLength
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, SniffSize))
+	if err != nil {
+		return errors.Wrap(err, "read body")
+	}
+
+	m.data = data
+	return nil
+}
+
+// sniffMIMEType returns the MIME type detected from data if it is known and the declared one otherwise.
+// Mismatches are reported as mime_mismatch counter.
+func (r *MediaRef) sniffMIMEType(declared string, data []byte) string {
+	sniffed := SniffMIMEType(data)
+	if sniffed == "" {
+		return declared
+	}
+
+	if sniffed != declared {
+		r.Manager.Metrics.Counter("mime_mismatch", metrics.Labels{
+			"feed_id", PrintID(r.FeedID),
+			"declared", declared,
+			"sniffed", sniffed,
+		}).Inc()
+	}
+
+	return sniffed
+}
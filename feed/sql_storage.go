@@ -3,7 +3,10 @@ package feed
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
@@ -18,10 +21,15 @@ import (
 )
 
 var (
-	Table     = goqu.T("feed")
-	BlobTable = goqu.T("blob")
+	Table           = goqu.T("feed")
+	BlobTable       = goqu.T("blob")
+	HistoryTable    = goqu.T("history")
+	DedupScopeTable = goqu.T("dedup_scope")
+	FileIDTable     = goqu.T("file_id")
 )
 
+var HistorySize = 100
+
 type SQLBuilder interface {
 	ToSQL() (string, []interface{}, error)
 }
@@ -35,7 +43,16 @@ type SQLStorage struct {
 	*goqu.Database
 	flu.Clock
 	metrics.Registry
-	mu *flu.RWMutex
+	// BlobTTL is the time after which blobs which were not seen again are deleted.
+	// Blobs are kept forever if it is zero.
+	BlobTTL time.Duration
+	// BlobCleanInterval is the interval between expired blob cleanups and blob metrics updates
+	// made by RunBlobCleaner.
+	BlobCleanInterval time.Duration
+	// blobFeeds are feed IDs with blobs gauge set.
+	blobFeeds map[ID]bool
+	blobMu    sync.Mutex
+	mu        *flu.RWMutex
 }
 
 func NewSQLStorage(clock flu.Clock, driver, conn string) (*SQLStorage, error) {
@@ -71,21 +88,42 @@ func (s *SQLStorage) Init(ctx context.Context) ([]ID, error) {
 	if _, err := s.Database.ExecContext(ctx, sql); err != nil {
 		return nil, errors.Wrap(err, "create table")
 	}
+	if err := s.createBlobTable(ctx); err != nil {
+		return nil, err
+	}
+	sql = fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+	  feed_id BIGINT NOT NULL,
+	  scope VARCHAR(63) NOT NULL,
+	  UNIQUE(feed_id)
+	)`, DedupScopeTable.GetTable())
+	if _, err := s.Database.ExecContext(ctx, sql); err != nil {
+		return nil, errors.Wrap(err, "create dedup scope table")
+	}
 	sql = fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS %s (
-      feed_id BIGINT NOT NULL,
 	  url VARCHAR(1023) NOT NULL,
-      hash_type VARCHAR(15) NOT NULL,
-	  hash BYTEA NOT NULL,
-	  first_seen TIMESTAMP NOT NULL,
-	  last_seen TIMESTAMP,
-	  collisions SMALLINT NOT NULL DEFAULT 0,
-	  last_url VARCHAR(1023),
-	  UNIQUE(feed_id, url),
-	  UNIQUE(feed_id, hash_type, hash)
-	)`, BlobTable.GetTable())
+	  hash VARCHAR(64) NOT NULL,
+	  mime_type VARCHAR(63) NOT NULL,
+	  file_id VARCHAR(255) NOT NULL,
+	  updated_at TIMESTAMP NOT NULL,
+	  UNIQUE(url)
+	)`, FileIDTable.GetTable())
 	if _, err := s.Database.ExecContext(ctx, sql); err != nil {
-		return nil, errors.Wrap(err, "create blob table")
+		return nil, errors.Wrap(err, "create file id table")
+	}
+	sql = fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+	  sub_id VARCHAR(255) NOT NULL,
+	  vendor VARCHAR(63) NOT NULL,
+	  feed_id BIGINT NOT NULL,
+	  seq BIGINT NOT NULL,
+	  data JSONB,
+	  delivered_at TIMESTAMP NOT NULL,
+	  UNIQUE(sub_id, vendor, feed_id, seq)
+	)`, HistoryTable.GetTable())
+	if _, err := s.Database.ExecContext(ctx, sql); err != nil {
+		return nil, errors.Wrap(err, "create history table")
 	}
 	activeSubs := make([]ID, 0)
 	err := s.Select(goqu.DISTINCT("feed_id")).
@@ -98,6 +136,57 @@ func (s *SQLStorage) Init(ctx context.Context) ([]ID, error) {
 	return activeSubs, nil
 }
 
+var blobTableSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
+	  scope VARCHAR(63) NOT NULL,
+	  feed_id BIGINT NOT NULL,
+	  url VARCHAR(1023) NOT NULL,
+	  hash_type VARCHAR(15) NOT NULL,
+	  hash BYTEA NOT NULL,
+	  first_seen TIMESTAMP NOT NULL,
+	  last_seen TIMESTAMP,
+	  collisions SMALLINT NOT NULL DEFAULT 0,
+	  last_url VARCHAR(1023),
+	  UNIQUE(scope, url),
+	  UNIQUE(scope, hash_type, hash)
+	)`
+
+// createBlobTable creates the blob table and migrates blobs stored before dedup scopes
+// were introduced to per-chat scopes.
+func (s *SQLStorage) createBlobTable(ctx context.Context) error {
+	table := BlobTable.GetTable()
+	_, err := s.Database.ExecContext(ctx, fmt.Sprintf("SELECT feed_id FROM %s LIMIT 0", table))
+	exists := err == nil
+	if exists {
+		if _, err := s.Database.ExecContext(ctx, fmt.Sprintf("SELECT scope FROM %s LIMIT 0", table)); err == nil {
+			return nil
+		}
+
+		if _, err := s.Database.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s_unscoped", table, table)); err != nil {
+			return errors.Wrap(err, "rename unscoped blob table")
+		}
+	}
+
+	if _, err := s.Database.ExecContext(ctx, fmt.Sprintf(blobTableSchema, table)); err != nil {
+		return errors.Wrap(err, "create blob table")
+	}
+
+	if exists {
+		if _, err := s.Database.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (scope, feed_id, url, hash_type, hash, first_seen, last_seen, collisions, last_url)
+		SELECT CAST(feed_id AS VARCHAR(63)), feed_id, url, hash_type, hash, first_seen, last_seen, collisions, last_url
+		FROM %s_unscoped`, table, table)); err != nil {
+			return errors.Wrap(err, "migrate unscoped blobs")
+		}
+
+		if _, err := s.Database.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s_unscoped", table)); err != nil {
+			return errors.Wrap(err, "drop unscoped blob table")
+		}
+	}
+
+	return nil
+}
+
 func (s *SQLStorage) ExecuteSQLBuilder(ctx context.Context, builder SQLBuilder) (int64, error) {
 	sql, args, err := builder.ToSQL()
 	if err != nil {
@@ -239,6 +328,12 @@ func (s *SQLStorage) DeleteSub(ctx context.Context, id SubID) error {
 		err = ErrNotFound
 	}
 
+	if err == nil {
+		if _, err := s.ExecuteSQLBuilder(ctx, s.Database.Delete(HistoryTable).Where(s.ByID(id))); err != nil {
+			return errors.Wrap(err, "delete history")
+		}
+	}
+
 	return err
 }
 
@@ -269,25 +364,91 @@ func (s *SQLStorage) UpdateSub(ctx context.Context, id SubID, value interface{})
 	return err
 }
 
-func (s *SQLStorage) CheckBlob(ctx context.Context, feedID ID, url string, hashType string, hash []byte) error {
+func (s *SQLStorage) SaveHistory(ctx context.Context, id SubID, delivery Delivery) error {
+	data, err := DataFrom(delivery)
+	if err != nil {
+		return errors.Wrap(err, "wrap data")
+	}
+
+	defer s.Lock().Unlock()
+	var seq int64
+	if _, err := s.Select(goqu.COALESCE(goqu.MAX("seq"), 0)).
+		From(HistoryTable).
+		Where(s.ByID(id)).
+		ScanValContext(ctx, &seq); err != nil {
+		return errors.Wrap(err, "select seq")
+	}
+
+	seq++
+	if _, err := s.ExecuteSQLBuilder(ctx, s.Insert(HistoryTable).
+		Cols("sub_id", "vendor", "feed_id", "seq", "data", "delivered_at").
+		Vals([]interface{}{id.ID, id.Vendor, id.FeedID, seq, data, s.Now().In(time.UTC)})); err != nil {
+		return errors.Wrap(err, "insert")
+	}
+
+	if _, err := s.ExecuteSQLBuilder(ctx, s.Database.Delete(HistoryTable).
+		Where(goqu.And(s.ByID(id), goqu.C("seq").Lte(seq-int64(HistorySize))))); err != nil {
+		return errors.Wrap(err, "trim")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) GetHistory(ctx context.Context, id SubID, limit int) ([]Delivery, error) {
+	defer s.RLock().Unlock()
+	// sqlite3 may store JSON values as text
+	var rows []string
+	if err := s.Select(goqu.C("data")).
+		From(goqu.Select(goqu.C("data"), goqu.C("seq")).
+			From(HistoryTable).
+			Where(s.ByID(id)).
+			Order(goqu.C("seq").Desc()).
+			Limit(uint(limit)).
+			As("recent")).
+		Order(goqu.C("seq").Asc()).
+		ScanValsContext(ctx, &rows); err != nil {
+		return nil, errors.Wrap(err, "select")
+	}
+
+	deliveries := make([]Delivery, len(rows))
+	for i, row := range rows {
+		if err := Data(row).ReadTo(&deliveries[i]); err != nil {
+			return nil, errors.Wrap(err, "read data")
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (s *SQLStorage) CheckBlob(ctx context.Context, scope string, feedID ID, url string, hashType string, hash []byte) error {
 	defer s.Lock().Unlock()
 	now := s.Now().In(time.UTC)
 
 	hashValue := fmt.Sprintf(`%x`, hash)
 	if updated, err := s.ExecuteSQLBuilder(ctx, s.Insert(BlobTable).
-		Cols("feed_id", "url", "hash_type", "hash", "first_seen").
-		Vals([]interface{}{feedID, url, hashType, hashValue, now}).
-		OnConflict(goqu.DoNothing())); err != nil {
+		Cols("scope", "feed_id", "url", "hash_type", "hash", "first_seen").
+		Vals([]interface{}{scope, feedID, url, hashType, hashValue, now}).
+		OnConflict(goqu.DoNothing()).
+		Prepared(true)); err != nil {
 		return errors.Wrap(err, "update")
 	} else if updated > 0 {
+		s.blobMu.Lock()
+		if s.blobFeeds == nil {
+			s.blobFeeds = make(map[ID]bool)
+		}
+
+		s.blobFeeds[feedID] = true
+		s.Gauge("blobs", metrics.Labels{"feed_id", PrintID(feedID)}).Inc()
+		s.blobMu.Unlock()
 		return nil
 	}
 
 	update := common.PlainSQLBuilder{
 		SQL: fmt.Sprintf(
-			"UPDATE %s SET collisions = collisions + 1, last_seen = $1, last_url = $2 WHERE url = $2 OR hash = $3 RETURNING url",
+			"UPDATE %s SET collisions = collisions + 1, last_seen = $1, last_url = $2 "+
+				"WHERE scope = $3 AND (url = $2 OR (hash_type = $4 AND hash = $5)) RETURNING url",
 			BlobTable.GetTable()),
-		Arguments: []interface{}{now, url, hashValue},
+		Arguments: []interface{}{now, url, scope, hashType, hashValue},
 	}
 
 	rows, err := s.QuerySQLBuilder(ctx, update)
@@ -310,21 +471,261 @@ func (s *SQLStorage) CheckBlob(ctx context.Context, feedID ID, url string, hashT
 	}
 }
 
-func (s *SQLStorage) BlobPage(ctx context.Context, feedID ID, hashType string, offset, limit uint) ([]string, error) {
-	urls := make([]string, 0)
-	if err := s.Database.Select(goqu.C("url")).
+func (s *SQLStorage) FindBlob(ctx context.Context, scope string, feedID ID, url string, hashType string, hash []byte) (string, error) {
+	defer s.RLock().Unlock()
+	rows, err := s.QuerySQLBuilder(ctx, s.Database.Select("url").
+		From(BlobTable).
+		Where(goqu.And(
+			goqu.C("scope").Eq(scope),
+			goqu.Or(
+				goqu.C("url").Eq(url),
+				goqu.And(
+					goqu.C("hash_type").Eq(hashType),
+					goqu.C("hash").Eq(fmt.Sprintf(`%x`, hash)))))).
+		Limit(1))
+	if err != nil {
+		return "", errors.Wrap(err, "select blob")
+	}
+
+	defer rows.Close()
+	oldURL := ""
+	for rows.Next() {
+		if err := rows.Scan(&oldURL); err != nil {
+			return "", errors.Wrap(err, "scan")
+		}
+	}
+
+	return oldURL, rows.Err()
+}
+
+func (s *SQLStorage) BlobHashes(ctx context.Context, scope string, hashType string) ([]BlobHash, error) {
+	defer s.RLock().Unlock()
+	rows, err := s.QuerySQLBuilder(ctx, s.Database.Select("url", "hash").
 		From(BlobTable).
+		Where(goqu.And(
+			goqu.C("scope").Eq(scope),
+			goqu.C("hash_type").Eq(hashType))))
+	if err != nil {
+		return nil, errors.Wrap(err, "select blobs")
+	}
+
+	defer rows.Close()
+	hashes := make([]BlobHash, 0)
+	for rows.Next() {
+		var (
+			url       string
+			hashValue []byte
+		)
+
+		if err := rows.Scan(&url, &hashValue); err != nil {
+			return nil, errors.Wrap(err, "scan")
+		}
+
+		hash, err := hex.DecodeString(string(hashValue))
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode hash for %s", url)
+		}
+
+		hashes = append(hashes, BlobHash{URL: url, Hash: hash})
+	}
+
+	return hashes, rows.Err()
+}
+
+// BlobPage returns blobs first sent to the feed ordered by the amount of collisions.
+// Only blobs which were seen at least twice are returned.
+func (s *SQLStorage) BlobPage(ctx context.Context, feedID ID, offset, limit uint) ([]BlobStat, error) {
+	defer s.RLock().Unlock()
+	stats := make([]BlobStat, 0)
+	if err := s.Database.From(BlobTable).
 		Where(goqu.And(
 			goqu.C("feed_id").Eq(feedID),
-			goqu.C("hash_type").Eq(hashType))).
+			goqu.C("collisions").Gt(0))).
 		Order(goqu.C("collisions").Desc(), goqu.C("url").Asc()).
 		Offset(offset).
 		Limit(limit).
-		ScanValsContext(ctx, &urls); err != nil {
+		ScanStructsContext(ctx, &stats); err != nil {
 		return nil, errors.Wrap(err, "select blobs")
 	}
 
-	return urls, nil
+	return stats, nil
+}
+
+// RunBlobCleaner deletes expired blobs and updates blob metrics every BlobCleanInterval until ctx is done.
+func (s *SQLStorage) RunBlobCleaner(ctx context.Context) {
+	if s.BlobCleanInterval <= 0 {
+		return
+	}
+
+	for {
+		if err := s.CleanBlobs(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("[blob] failed to clean: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.BlobCleanInterval):
+		}
+	}
+}
+
+// CleanBlobs deletes blobs which expired according to BlobTTL and resets blob metrics to the actual counts.
+func (s *SQLStorage) CleanBlobs(ctx context.Context) error {
+	counts, err := s.cleanBlobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	if s.blobFeeds == nil {
+		s.blobFeeds = make(map[ID]bool)
+	}
+
+	for feedID := range counts {
+		s.blobFeeds[feedID] = true
+	}
+
+	for feedID := range s.blobFeeds {
+		s.Gauge("blobs", metrics.Labels{"feed_id", PrintID(feedID)}).Set(float64(counts[feedID]))
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) cleanBlobs(ctx context.Context) (map[ID]int64, error) {
+	defer s.Lock().Unlock()
+	if s.BlobTTL > 0 {
+		expiry := s.Now().Add(-s.BlobTTL).In(time.UTC)
+		// timestamps are passed as arguments to be formatted consistently with updates
+		deleted, err := s.ExecuteSQLBuilder(ctx, s.Database.Delete(BlobTable).
+			Where(goqu.Or(
+				goqu.C("last_seen").Lt(expiry),
+				goqu.And(goqu.C("last_seen").IsNull(), goqu.C("first_seen").Lt(expiry)))).
+			Prepared(true))
+		if err != nil {
+			return nil, errors.Wrap(err, "delete")
+		}
+
+		log.Printf("[blob] deleted %d expired blobs", deleted)
+	}
+
+	rows, err := s.QuerySQLBuilder(ctx, s.Database.
+		Select(goqu.C("feed_id"), goqu.COUNT("*")).
+		From(BlobTable).
+		GroupBy(goqu.C("feed_id")))
+	if err != nil {
+		return nil, errors.Wrap(err, "count")
+	}
+
+	defer rows.Close()
+	counts := make(map[ID]int64)
+	for rows.Next() {
+		var (
+			feedID ID
+			count  int64
+		)
+
+		if err := rows.Scan(&feedID, &count); err != nil {
+			return nil, errors.Wrap(err, "scan")
+		}
+
+		counts[feedID] = count
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *SQLStorage) GetDedupScope(ctx context.Context, feedID ID) (string, error) {
+	defer s.RLock().Unlock()
+	var scope string
+	if ok, err := s.Select(goqu.C("scope")).
+		From(DedupScopeTable).
+		Where(goqu.C("feed_id").Eq(feedID)).
+		ScanValContext(ctx, &scope); err != nil {
+		return "", errors.Wrap(err, "select")
+	} else if !ok {
+		return ChatDedupScope(feedID), nil
+	}
+
+	return scope, nil
+}
+
+func (s *SQLStorage) SetDedupScope(ctx context.Context, feedID ID, scope string) error {
+	defer s.Lock().Unlock()
+	if _, err := s.ExecuteSQLBuilder(ctx, s.Database.Delete(DedupScopeTable).
+		Where(goqu.C("feed_id").Eq(feedID))); err != nil {
+		return errors.Wrap(err, "delete")
+	}
+
+	if scope == ChatDedupScope(feedID) {
+		return nil
+	}
+
+	if _, err := s.ExecuteSQLBuilder(ctx, s.Insert(DedupScopeTable).
+		Cols("feed_id", "scope").
+		Vals([]interface{}{feedID, scope})); err != nil {
+		return errors.Wrap(err, "insert")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) ListDedupScope(ctx context.Context, scope string) ([]ID, error) {
+	defer s.RLock().Unlock()
+	feedIDs := make([]ID, 0)
+	if err := s.Select(goqu.C("feed_id")).
+		From(DedupScopeTable).
+		Where(goqu.C("scope").Eq(scope)).
+		Order(goqu.C("feed_id").Asc()).
+		ScanValsContext(ctx, &feedIDs); err != nil {
+		return nil, errors.Wrap(err, "select")
+	}
+
+	return feedIDs, nil
+}
+
+func (s *SQLStorage) GetFileID(ctx context.Context, url, hash string) (*FileID, error) {
+	defer s.RLock().Unlock()
+	conditions := []goqu.Expression{goqu.C("url").Eq(url)}
+	if hash != "" {
+		conditions = append(conditions, goqu.C("hash").Eq(hash))
+	}
+
+	for _, condition := range conditions {
+		fileID := new(FileID)
+		if ok, err := s.Database.From(FileIDTable).
+			Where(condition).
+			Order(goqu.C("updated_at").Desc()).
+			ScanStructContext(ctx, fileID); err != nil {
+			return nil, errors.Wrap(err, "select")
+		} else if ok {
+			return fileID, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *SQLStorage) SetFileID(ctx context.Context, fileID FileID) error {
+	defer s.Lock().Unlock()
+	if _, err := s.ExecuteSQLBuilder(ctx, s.Database.Delete(FileIDTable).
+		Where(goqu.C("url").Eq(fileID.URL))); err != nil {
+		return errors.Wrap(err, "delete")
+	}
+
+	if _, err := s.ExecuteSQLBuilder(ctx, s.Insert(FileIDTable).
+		Cols("url", "hash", "mime_type", "file_id", "updated_at").
+		Vals([]interface{}{fileID.URL, fileID.Hash, fileID.MIMEType, fileID.FileID, s.Now()})); err != nil {
+		return errors.Wrap(err, "insert")
+	}
+
+	return nil
 }
 
 func (s *SQLStorage) Close() error {
@@ -7,7 +7,8 @@ import (
 	"time"
 
 	"github.com/jfk9w-go/flu"
-
+	"github.com/jfk9w-go/flu/metrics"
+	"github.com/jfk9w-go/telegram-bot-api/format"
 	"github.com/jfk9w/hikkabot/feed"
 	"github.com/stretchr/testify/assert"
 )
@@ -23,6 +24,7 @@ func (c *testClock) Now() time.Time {
 func newTestSQLite3(t *testing.T, clock flu.Clock) *feed.SQLStorage {
 	store, err := feed.NewSQLStorage(clock, "sqlite3", ":memory:")
 	assert.Nil(t, err)
+	store.Registry = metrics.DummyRegistry{}
 	return store
 }
 
@@ -91,3 +93,156 @@ func TestSQLite3_Basic(t *testing.T) {
 	stored, err = store.NextSub(ctx, sub.FeedID)
 	assert.Equal(t, feed.ErrNotFound, err)
 }
+
+func TestSQLite3_History(t *testing.T) {
+	store := newTestSQLite3(t, new(testClock))
+	defer store.Close()
+
+	ctx := context.Background()
+	_, err := store.Init(ctx)
+	assert.Nil(t, err)
+
+	historySize := feed.HistorySize
+	defer func() { feed.HistorySize = historySize }()
+	feed.HistorySize = 3
+
+	subID := feed.SubID{"1", "test", 1}
+	deliveries, err := store.GetHistory(ctx, subID, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, deliveries)
+	for _, text := range []string{"1", "2", "3", "4"} {
+		assert.Nil(t, store.SaveHistory(ctx, subID, feed.Delivery{{Text: text}}))
+	}
+
+	deliveries, err = store.GetHistory(ctx, subID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []feed.Delivery{{{Text: "4"}}}, deliveries)
+	deliveries, err = store.GetHistory(ctx, subID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []feed.Delivery{{{Text: "2"}}, {{Text: "3"}}, {{Text: "4"}}}, deliveries)
+}
+
+func TestSQLite3_DedupScope(t *testing.T) {
+	store := newTestSQLite3(t, new(testClock))
+	defer store.Close()
+
+	ctx := context.Background()
+	_, err := store.Init(ctx)
+	assert.Nil(t, err)
+
+	scope, err := store.GetDedupScope(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, feed.ChatDedupScope(1), scope)
+
+	assert.Nil(t, store.SetDedupScope(ctx, 1, "group"))
+	assert.Nil(t, store.SetDedupScope(ctx, 2, "group"))
+	assert.Nil(t, store.SetDedupScope(ctx, 3, feed.GlobalDedupScope))
+	scope, err = store.GetDedupScope(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "group", scope)
+	feedIDs, err := store.ListDedupScope(ctx, "group")
+	assert.Nil(t, err)
+	assert.Equal(t, []feed.ID{1, 2}, feedIDs)
+
+	assert.Nil(t, store.CheckBlob(ctx, "group", 1, "a", "md5", []byte{1}))
+	assert.True(t, errors.Is(store.CheckBlob(ctx, "group", 2, "b", "md5", []byte{1}), format.ErrSkipMedia))
+	assert.Nil(t, store.CheckBlob(ctx, feed.GlobalDedupScope, 3, "b", "md5", []byte{1}))
+
+	assert.Nil(t, store.SetDedupScope(ctx, 2, feed.ChatDedupScope(2)))
+	scope, err = store.GetDedupScope(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, feed.ChatDedupScope(2), scope)
+}
+
+func TestSQLite3_UnscopedBlobMigration(t *testing.T) {
+	store := newTestSQLite3(t, new(testClock))
+	defer store.Close()
+
+	ctx := context.Background()
+	_, err := store.ExecContext(ctx, `
+	CREATE TABLE blob (
+	  feed_id BIGINT NOT NULL,
+	  url VARCHAR(1023) NOT NULL,
+	  hash_type VARCHAR(15) NOT NULL,
+	  hash BYTEA NOT NULL,
+	  first_seen TIMESTAMP NOT NULL,
+	  last_seen TIMESTAMP,
+	  collisions SMALLINT NOT NULL DEFAULT 0,
+	  last_url VARCHAR(1023),
+	  UNIQUE(feed_id, url),
+	  UNIQUE(feed_id, hash_type, hash)
+	)`)
+	assert.Nil(t, err)
+	_, err = store.ExecContext(ctx, `INSERT INTO blob (feed_id, url, hash_type, hash, first_seen) VALUES (1, 'a', 'md5', '01', '2020-01-01')`)
+	assert.Nil(t, err)
+
+	_, err = store.Init(ctx)
+	assert.Nil(t, err)
+	_, err = store.Init(ctx)
+	assert.Nil(t, err)
+
+	assert.True(t, errors.Is(store.CheckBlob(ctx, feed.ChatDedupScope(1), 1, "b", "md5", []byte{1}), format.ErrSkipMedia))
+	assert.Nil(t, store.CheckBlob(ctx, feed.ChatDedupScope(2), 2, "b", "md5", []byte{1}))
+}
+
+func TestSQLite3_BlobRetention(t *testing.T) {
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newTestSQLite3(t, clock)
+	store.BlobTTL = 24 * time.Hour
+	store.BlobCleanInterval = time.Hour
+	defer store.Close()
+
+	ctx := context.Background()
+	_, err := store.Init(ctx)
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.CheckBlob(ctx, "1", 1, "a", "md5", []byte{1}))
+	assert.Nil(t, store.CheckBlob(ctx, "1", 1, "b", "md5", []byte{2}))
+	clock.now = clock.now.Add(12 * time.Hour)
+	assert.NotNil(t, store.CheckBlob(ctx, "1", 1, "c", "md5", []byte{1}))
+	assert.NotNil(t, store.CheckBlob(ctx, "1", 1, "a", "md5", []byte{1}))
+
+	stats, err := store.BlobPage(ctx, 1, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, "a", stats[0].URL)
+	assert.Equal(t, 2, stats[0].Collisions)
+	assert.Equal(t, clock.now, stats[0].LastSeen)
+
+	clock.now = clock.now.Add(18 * time.Hour)
+	assert.Nil(t, store.CleanBlobs(ctx))
+	assert.Nil(t, store.CheckBlob(ctx, "1", 1, "d", "md5", []byte{2}))
+	assert.NotNil(t, store.CheckBlob(ctx, "1", 1, "e", "md5", []byte{1}))
+}
+
+func TestSQLite3_FileID(t *testing.T) {
+	clock := new(testClock)
+	store := newTestSQLite3(t, clock)
+	defer store.Close()
+
+	ctx := context.Background()
+	_, err := store.Init(ctx)
+	assert.Nil(t, err)
+
+	fileID, err := store.GetFileID(ctx, "a", "")
+	assert.Nil(t, err)
+	assert.Nil(t, fileID)
+
+	assert.Nil(t, store.SetFileID(ctx, feed.FileID{URL: "a", Hash: "h", MIMEType: "video/mp4", FileID: "1"}))
+	fileID, err = store.GetFileID(ctx, "a", "")
+	assert.Nil(t, err)
+	assert.Equal(t, &feed.FileID{URL: "a", Hash: "h", MIMEType: "video/mp4", FileID: "1"}, fileID)
+
+	fileID, err = store.GetFileID(ctx, "b", "h")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", fileID.FileID)
+
+	assert.Nil(t, store.SetFileID(ctx, feed.FileID{URL: "a", Hash: "h", MIMEType: "video/mp4", FileID: "2"}))
+	fileID, err = store.GetFileID(ctx, "a", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", fileID.FileID)
+
+	fileID, err = store.GetFileID(ctx, "b", "")
+	assert.Nil(t, err)
+	assert.Nil(t, fileID)
+}
@@ -0,0 +1,188 @@
+package feed
+
+import (
+	"context"
+	"log"
+
+	"github.com/jfk9w-go/flu"
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/botapi"
+	"github.com/pkg/errors"
+)
+
+// TransportFactory creates transports for sending already rendered messages, e.g. on replay.
+// It is an optional extension of HTMLWriterFactory.
+type TransportFactory interface {
+	CreateTransport(ctx context.Context, feedIDs ...ID) (format.Transport, error)
+}
+
+type deliveryKey struct{}
+
+// withDelivery makes transports created with the context record sent messages into the delivery.
+func withDelivery(ctx context.Context, delivery *Delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, delivery)
+}
+
+func getDelivery(ctx context.Context) *Delivery {
+	delivery, _ := ctx.Value(deliveryKey{}).(*Delivery)
+	return delivery
+}
+
+type replyMarkupKey struct{}
+
+// WithReplyMarkup attaches the reply markup to messages sent with the context.
+// It should be used instead of format.WithReplyMarkup,
+// so that the markup is sent along with media sent by TelegramHTML transports as well.
+func WithReplyMarkup(ctx context.Context, markup telegram.ReplyMarkup) context.Context {
+	return context.WithValue(format.WithReplyMarkup(ctx, markup), replyMarkupKey{}, markup)
+}
+
+func getReplyMarkup(ctx context.Context) telegram.ReplyMarkup {
+	markup, _ := ctx.Value(replyMarkupKey{}).(telegram.ReplyMarkup)
+	return markup
+}
+
+// telegramTransport sends messages with format.TelegramTransport
+// and records them into the delivery, if any.
+// Media submitted to MediaManager is sent with media sender along with its video attributes.
+type telegramTransport struct {
+	*format.TelegramTransport
+	media    *botapi.MediaSender
+	feedIDs  []ID
+	delivery *Delivery
+}
+
+func (t *telegramTransport) Text(ctx context.Context, text string, disableWebPagePreview bool) error {
+	if err := t.TelegramTransport.Text(ctx, text, disableWebPagePreview); err != nil {
+		return err
+	}
+
+	t.record(DeliveredMessage{Text: text, DisableWebPagePreview: disableWebPagePreview})
+	return nil
+}
+
+func (t *telegramTransport) Media(ctx context.Context, ref format.MediaRef, caption string, collapsible bool) error {
+	if mvar, ok := ref.(*MediaVar); ok && t.media != nil {
+		// the library reports media which failed to process
+		if media, err := mvar.Resolve(ctx); err == nil {
+			return t.sendMedia(ctx, ref, media, caption, collapsible)
+		}
+	}
+
+	if err := t.TelegramTransport.Media(ctx, ref, caption, collapsible); err != nil {
+		return err
+	}
+
+	if t.delivery != nil {
+		// only the caption is recorded for media which failed to process
+		media, _ := ref.Get(ctx)
+		t.recordMedia(media, caption, collapsible)
+	}
+
+	return nil
+}
+
+func (t *telegramTransport) sendMedia(ctx context.Context, ref format.MediaRef, media Media, caption string, collapsible bool) error {
+	outgoing := botapi.OutgoingMedia{
+		Type:        string(telegram.MediaTypeByMIMEType(media.MIMEType)),
+		MIMEType:    media.MIMEType,
+		Caption:     caption,
+		ParseMode:   string(telegram.HTML),
+		ReplyMarkup: getReplyMarkup(ctx),
+		Attributes:  media.Attributes,
+	}
+
+	if url, ok := media.Input.(flu.URL); ok {
+		outgoing.URL = string(url)
+	} else {
+		outgoing.Input = media.Input
+	}
+
+	var fileID string
+	for _, feedID := range t.feedIDs {
+		sentFileID, err := t.media.Send(ctx, int64(feedID), outgoing)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+
+			// the library falls back to other means of sending the media if it is rejected
+			log.Printf("[media > %d] failed to send %s, retrying with the library: %s", feedID, media.MIMEType, err)
+			transport := &format.TelegramTransport{
+				Sender:  t.Sender,
+				ChatIDs: []telegram.ChatID{telegram.ID(feedID)},
+				Strict:  t.Strict,
+			}
+
+			if err := transport.Media(ctx, ref, caption, collapsible); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if fileID == "" && sentFileID != "" {
+			// the media is not uploaded again for other chats
+			fileID = sentFileID
+			outgoing.URL, outgoing.Input = fileID, nil
+			media.sent(ctx, fileID)
+		}
+	}
+
+	recorded := media.Media
+	if fileID != "" {
+		recorded.Input = flu.URL(fileID)
+	}
+
+	t.recordMedia(recorded, caption, collapsible)
+	return nil
+}
+
+// recordMedia records the media message.
+// Uploaded media without file_id can not be sent again, so only its caption is recorded.
+func (t *telegramTransport) recordMedia(media format.Media, caption string, collapsible bool) {
+	message := DeliveredMessage{Text: caption}
+	if url, ok := media.Input.(flu.URL); ok {
+		message.MIMEType = media.MIMEType
+		message.URL = string(url)
+		message.Collapsible = collapsible
+	}
+
+	t.record(message)
+}
+
+func (t *telegramTransport) record(message DeliveredMessage) {
+	if t.delivery != nil && (message.Text != "" || message.URL != "") {
+		*t.delivery = append(*t.delivery, message)
+	}
+}
+
+// deliveredMedia is a format.MediaRef for media recorded in a Delivery.
+type deliveredMedia DeliveredMessage
+
+func (m deliveredMedia) Get(_ context.Context) (format.Media, error) {
+	return format.Media{
+		MIMEType: m.MIMEType,
+		Input:    flu.URL(m.URL),
+	}, nil
+}
+
+// send sends the delivery messages with the transport.
+func (d Delivery) send(ctx context.Context, transport format.Transport) error {
+	ctx = format.WithParseMode(ctx, telegram.HTML)
+	for _, message := range d {
+		var err error
+		if message.URL != "" {
+			err = transport.Media(ctx, deliveredMedia(message), message.Text, message.Collapsible)
+		} else {
+			err = transport.Text(ctx, message.Text, message.DisableWebPagePreview)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "send")
+		}
+	}
+
+	return nil
+}
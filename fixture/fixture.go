@@ -0,0 +1,237 @@
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/pkg/errors"
+)
+
+// RecordEnv is the environment variable which switches fixture servers to record mode.
+const RecordEnv = "FIXTURE_RECORD"
+
+var recordedHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Set-Cookie", "Location"}
+
+type Interaction struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Status int             `json:"status"`
+	Header http.Header     `json:"header,omitempty"`
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Body   string          `json:"body,omitempty"`
+}
+
+func (i *Interaction) key() string {
+	return i.Method + " " + i.URL
+}
+
+type Server struct {
+	*httptest.Server
+	Path         string
+	Upstream     string
+	Client       *http.Client
+	redacted     map[string]bool
+	interactions []Interaction
+	served       map[string]int
+	missed       []string
+	mu           flu.Mutex
+}
+
+func NewServer(path, upstream string) (*Server, error) {
+	s := &Server{
+		Path:     path,
+		Upstream: upstream,
+		Client:   http.DefaultClient,
+		redacted: make(map[string]bool),
+		served:   make(map[string]int),
+	}
+
+	if upstream == "" {
+		if err := flu.DecodeFrom(flu.File(path), flu.JSON{Value: &s.interactions}); err != nil {
+			return nil, errors.Wrapf(err, "read %s", path)
+		}
+	}
+
+	s.Server = httptest.NewServer(s)
+	return s, nil
+}
+
+// Serve starts a fixture server for the test which replays interactions from path.
+// If RecordEnv is set, requests are proxied to upstream instead and saved to path on cleanup.
+func Serve(t *testing.T, path, upstream string, redact ...string) *Server {
+	if os.Getenv(RecordEnv) == "" {
+		upstream = ""
+	}
+
+	s, err := NewServer(path, upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Redact(redact...)
+	t.Cleanup(func() {
+		s.Close()
+		if s.Upstream != "" {
+			if err := s.Save(); err != nil {
+				t.Error(err)
+			}
+		}
+
+		for _, key := range s.Missed() {
+			t.Errorf("no fixture for %s", key)
+		}
+	})
+
+	return s
+}
+
+// Redact replaces the values of the query parameters with a placeholder
+// both in recorded and in matched requests.
+func (s *Server) Redact(params ...string) *Server {
+	for _, param := range params {
+		s.redacted[param] = true
+	}
+
+	return s
+}
+
+func (s *Server) requestURL(req *http.Request) string {
+	query := req.URL.Query()
+	for param := range query {
+		if s.redacted[param] {
+			query.Set(param, "REDACTED")
+		}
+	}
+
+	if len(query) == 0 {
+		return req.URL.Path
+	}
+
+	return req.URL.Path + "?" + query.Encode()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var interaction *Interaction
+	if s.Upstream != "" {
+		var err error
+		interaction, err = s.record(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	} else {
+		interaction = s.replay(req)
+		if interaction == nil {
+			http.NotFound(w, req)
+			return
+		}
+	}
+
+	for key, values := range interaction.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	w.WriteHeader(interaction.Status)
+	if interaction.JSON != nil {
+		_, _ = w.Write(interaction.JSON)
+	} else {
+		_, _ = w.Write([]byte(interaction.Body))
+	}
+}
+
+func (s *Server) replay(req *http.Request) *Interaction {
+	defer s.mu.Lock().Unlock()
+	key := (&Interaction{Method: req.Method, URL: s.requestURL(req)}).key()
+	matches := make([]*Interaction, 0)
+	for i := range s.interactions {
+		if s.interactions[i].key() == key {
+			matches = append(matches, &s.interactions[i])
+		}
+	}
+
+	if len(matches) == 0 {
+		s.missed = append(s.missed, key)
+		return nil
+	}
+
+	// identical requests are replayed in order, the last one is repeated
+	idx := s.served[key]
+	if idx >= len(matches) {
+		idx = len(matches) - 1
+	}
+
+	s.served[key]++
+	return matches[idx]
+}
+
+func (s *Server) record(req *http.Request) (*Interaction, error) {
+	upstreamReq, err := http.NewRequestWithContext(req.Context(), req.Method,
+		strings.TrimRight(s.Upstream, "/")+req.URL.RequestURI(), req.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "create upstream request")
+	}
+
+	for key, values := range req.Header {
+		upstreamReq.Header[key] = values
+	}
+
+	resp, err := s.Client.Do(upstreamReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "execute upstream request")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read upstream response")
+	}
+
+	interaction := Interaction{
+		Method: req.Method,
+		URL:    s.requestURL(req),
+		Status: resp.StatusCode,
+		Header: make(http.Header),
+	}
+
+	for _, key := range recordedHeaders {
+		if values := resp.Header.Values(key); len(values) > 0 {
+			interaction.Header[key] = values
+		}
+	}
+
+	if trimmed := bytes.TrimSpace(body); json.Valid(trimmed) && len(trimmed) > 0 {
+		interaction.JSON = trimmed
+	} else {
+		interaction.Body = string(body)
+	}
+
+	defer s.mu.Lock().Unlock()
+	s.interactions = append(s.interactions, interaction)
+	return &interaction, nil
+}
+
+// Save writes recorded interactions to the fixture file.
+func (s *Server) Save() error {
+	defer s.mu.Lock().Unlock()
+	data, err := json.MarshalIndent(s.interactions, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+
+	return errors.Wrapf(ioutil.WriteFile(s.Path, append(data, '\n'), 0644), "write %s", s.Path)
+}
+
+// Missed returns the requests which had no matching fixture.
+func (s *Server) Missed() []string {
+	defer s.mu.Lock().Unlock()
+	return append([]string(nil), s.missed...)
+}
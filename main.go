@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"net/url"
 	"os"
 	"time"
 
@@ -12,9 +15,10 @@ import (
 	"github.com/jfk9w-go/flu/serde"
 	telegram "github.com/jfk9w-go/telegram-bot-api"
 	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/botapi"
 	"github.com/jfk9w/hikkabot/feed"
 	"github.com/jfk9w/hikkabot/resolver"
-	"github.com/jfk9w/hikkabot/vendors/common"
+	"github.com/jfk9w/hikkabot/sink"
 	"github.com/jfk9w/hikkabot/vendors/dvach"
 	"github.com/jfk9w/hikkabot/vendors/reddit"
 	"github.com/pkg/errors"
@@ -89,6 +93,92 @@ type Config struct {
 		// CURL denotes the path to cURL binary for use as a fallback HTTP client. Optional.
 		// This may come in handy as I suspect there are issues with Go HTTP/2 implementation.
 		CURL string
+
+		// FFmpeg denotes the path to ffmpeg binary used for extracting video frames
+		// for perceptual deduplication, generating video thumbnails and muxing v.redd.it video and audio.
+		// Optional, videos are compared by md5 and sent without thumbnails and v.redd.it sound if not set.
+		FFmpeg string
+
+		// Convert enables local media conversion with FFmpeg instead of aconvert.com.
+		// webm and gif are converted to mp4. Videos which are too large are downscaled with FFmpeg regardless of this option.
+		Convert bool
+
+		// Conversions is the maximum amount of simultaneous ffmpeg conversions. Default is 1.
+		// This also applies to transcoding videos which are too large.
+		Conversions int
+
+		// Presets override ffmpeg output arguments for source MIME types.
+		// See resolver.FFmpegPresets for defaults.
+		Presets map[string][]string
+
+		// Concurrency is the maximum amount of simultaneous media jobs for all hosts. Default is 3.
+		Concurrency int
+
+		// Hosts overrides media download limits by host domain (see feed.DefaultHostLimits).
+		// Subdomains share limits of the domain.
+		// Use "*" key in order to override feed.DefaultHostLimit used for other hosts.
+		Hosts map[string]feed.HostLimit
+
+		// Resolvers configures how media links are resolved to direct media URLs.
+		// Built-in resolvers (see resolver.DefaultRules and resolver.PageResolverName) may be disabled by name,
+		// URL rewrites may be added and HTTP clients may be set up by domain.
+		// Imgur albums are resolved only if Imgur API client ID is set.
+		Resolvers resolver.Config
+
+		// AlbumLimit is the maximum amount of media relayed from a single album. Default is 10.
+		AlbumLimit int
+
+		// Circuits describes circuit breakers for resolvers and converters (see feed.CircuitBreakers).
+		// A resolver or converter which keeps failing is not called until Cooldown passes:
+		// original links are sent instead of media and conversion is skipped meanwhile.
+		Circuits struct {
+
+			// Threshold is the amount of consecutive failures which trips the breaker. Default is 5.
+			Threshold int
+
+			// Cooldown is the time after which a tripped resolver or converter is probed again. Default is 5m.
+			Cooldown serde.Duration
+		}
+
+		// YtDlp describes yt-dlp based resolver for video hosts.
+		YtDlp struct {
+
+			// Binary denotes the path to yt-dlp binary. Optional, the resolver is disabled if not set.
+			Binary string
+
+			// Domains are video host domains resolved with yt-dlp including their subdomains.
+			// Default is resolver.DefaultYtDlpDomains.
+			Domains []string
+		}
+
+		// Cache describes persistent media cache which is used in order not to download
+		// the same media again when it is sent to several chats.
+		Cache struct {
+
+			// Directory is a path of the cache directory. Optional, the cache is disabled if not set.
+			Directory string
+
+			// MaxSize is the maximum cache size in megabytes. Default is 1024.
+			MaxSize int64
+		}
+	}
+
+	// Dedup describes media near-duplicate detection settings.
+	Dedup struct {
+
+		// Thresholds maps hash types ("dhash" for images, "vdhash" for videos and animations,
+		// "simhash" for texts of subscriptions with "dedup" option)
+		// to the maximum Hamming distance per 64 bits of hash at which media is considered a duplicate.
+		// Media is deduplicated by exact hash match if the hash type is not specified.
+		Thresholds map[string]int
+
+		// Feeds overrides Thresholds for specific feed IDs, for example
+		// in order to disable near-duplicate detection with zero thresholds.
+		Feeds map[feed.ID]map[string]int
+
+		// TTL is the amount of time after which media which has not been seen again is forgotten.
+		// Optional, media is remembered forever by default.
+		TTL serde.Duration
 	}
 
 	// Telegram describes telegram related settings.
@@ -104,8 +194,24 @@ type Config struct {
 		// These may be used for defining shortcuts of chat names or
 		// providing access to private channels or groups.
 		Aliases map[string]telegram.ID
+
+		// BaseURL is the Bot API server URL. Optional, https://api.telegram.org by default.
+		// May be used with a self-hosted Bot API server.
+		BaseURL string
+
+		// DryRun replaces Telegram with a local Bot API stand-in which logs all sent messages
+		// instead of delivering them. Commands passed as extra command-line arguments
+		// are sent to the bot on behalf of the Supervisor, for example:
+		//   hikkabot config.yml "/sub /r/meirl . !m"
+		// It is advised to use an in-memory datasource with this option.
+		DryRun bool
 	}
 
+	// Sinks maps feed IDs to non-Telegram outputs (Discord, Matrix, webhooks or files).
+	// Feed IDs used here should not collide with real chat IDs and
+	// are better given an alias in Telegram.Aliases for use with /sub.
+	Sinks map[feed.ID]sink.Config
+
 	// Reddit describes reddit.com client configuration.
 	Reddit *reddit.Config
 
@@ -149,48 +255,178 @@ func main() {
 	}
 
 	store.Registry = metricsRegistry.WithPrefix("store")
-	aconvert := resolver.Aconvert{
-		Client: aconvert.NewClient(nil, config.Aconvert.Servers, config.Aconvert.Probe),
+	store.BlobTTL = config.Dedup.TTL.Duration
+	store.BlobCleanInterval = time.Hour
+	go store.RunBlobCleaner(ctx)
+	if config.Media.Concurrency <= 0 {
+		config.Media.Concurrency = 3
+	}
+
+	if config.Media.AlbumLimit <= 0 {
+		config.Media.AlbumLimit = 10
+	}
+
+	var ffmpeg *resolver.FFmpeg
+	if config.Media.FFmpeg != "" {
+		if config.Media.Conversions <= 0 {
+			config.Media.Conversions = 1
+		}
+
+		ffmpeg = &resolver.FFmpeg{
+			Binary:      config.Media.FFmpeg,
+			Presets:     config.Media.Presets,
+			RateLimiter: flu.ConcurrencyRateLimiter(config.Media.Conversions),
+		}
+	}
+
+	var converter feed.MediaConverter
+	if config.Media.Convert {
+		if ffmpeg == nil {
+			check(errors.New("media.ffmpeg is required for media.convert"))
+		}
+
+		converter = ffmpeg
+	} else {
+		converter = resolver.Aconvert{
+			Client: aconvert.NewClient(nil, config.Aconvert.Servers, config.Aconvert.Probe),
+		}
+	}
+
+	noFileIDs := make(map[feed.ID]bool)
+	for feedID := range config.Sinks {
+		noFileIDs[feedID] = true
+	}
+
+	var prober feed.MediaProber
+	if ffmpeg != nil {
+		prober = ffmpeg
+	}
+
+	var cache feed.MediaCache
+	if config.Media.Cache.Directory != "" {
+		fileCache, err := (&feed.FileMediaCache{
+			Directory: config.Media.Cache.Directory,
+			MaxSize:   config.Media.Cache.MaxSize << 20,
+		}).Init()
+		check(err)
+		defer fileCache.Close()
+		cache = fileCache
+	}
+
+	rules := make([]resolver.Rule, 0)
+	if config.Media.YtDlp.Binary != "" {
+		rules = append(rules, resolver.YtDlpRule(config.Media.YtDlp.Binary, config.Media.YtDlp.Domains))
+	}
+
+	resolvers, err := resolver.NewRegistry(config.Media.Resolvers, rules...)
+	check(err)
+
+	breakers := &feed.CircuitBreakers{
+		Threshold: config.Media.Circuits.Threshold,
+		Cooldown:  config.Media.Circuits.Cooldown.Duration,
+		Metrics:   metricsRegistry.WithPrefix("media"),
 	}
 
 	mediam := (&feed.MediaManager{
 		DefaultClient: fluhttp.NewTransport().NewClient(),
+		Resolvers:     resolvers,
+		PageResolver:  resolvers.PageResolver(),
+		Breakers:      breakers,
 		SizeBounds:    [2]int64{1 << 10, 75 << 20},
 		Storage:       blobs,
 		Dedup: feed.DefaultMediaDedup{
-			BlobStorage: store,
+			BlobStorage: &feed.NearBlobStorage{
+				BlobHashStorage: store,
+				Thresholds:      config.Dedup.Thresholds,
+				Feeds:           config.Dedup.Feeds,
+				RefreshInterval: store.BlobCleanInterval,
+			},
+			Scopes: store,
+			FFmpeg: config.Media.FFmpeg,
+		},
+		Downscaler: &resolver.Downscaler{
+			FFmpeg:  ffmpeg,
+			Metrics: metricsRegistry.WithPrefix("media"),
 		},
-		RateLimiter: flu.ConcurrencyRateLimiter(3),
+		Prober:    prober,
+		Cache:     cache,
+		FileIDs:   store,
+		NoFileIDs: noFileIDs,
+		HostLimiter: &feed.HostLimiter{
+			Limits:  config.Media.Hosts,
+			Metrics: metricsRegistry.WithPrefix("media"),
+		},
+		RateLimiter: flu.ConcurrencyRateLimiter(config.Media.Concurrency),
 		Metrics:     metricsRegistry.WithPrefix("media"),
 		Retries:     config.Media.Retries,
 		CURL:        config.Media.CURL,
+		AlbumLimit:  config.Media.AlbumLimit,
 	}).Init(ctx)
-	defer mediam.Converter(aconvert).Close()
+	if ffmpeg != nil {
+		mediam.Converter(resolver.DASH{FFmpeg: ffmpeg})
+	}
+
+	defer mediam.Converter(converter).Close()
 
 	executor := feed.NewTaskExecutor()
 	defer executor.Close()
 
-	bot := telegram.NewBot(fluhttp.NewTransport().
-		ResponseHeaderTimeout(2*time.Minute).
-		NewClient(), config.Telegram.Token)
+	botClient := fluhttp.NewTransport().
+		ResponseHeaderTimeout(2 * time.Minute).
+		NewClient()
+	if config.Telegram.DryRun {
+		server := botapi.NewServer()
+		defer server.Close()
+		server.OnSend = logDryRun
+		for _, command := range os.Args[2:] {
+			supervisor := int64(config.Telegram.Supervisor)
+			server.Command(supervisor, supervisor, command)
+		}
+
+		botClient = server.Client()
+	} else if config.Telegram.BaseURL != "" {
+		botClient, err = botapi.NewClient(config.Telegram.BaseURL)
+		check(err)
+	}
+
+	bot := telegram.NewBot(botClient, config.Telegram.Token)
+
+	var htmlWriterFactory feed.HTMLWriterFactory = feed.TelegramHTML{
+		Sender: bot,
+		Media:  &botapi.MediaSender{Client: botClient.Client, Token: config.Telegram.Token},
+	}
+	if len(config.Sinks) > 0 {
+		router := &sink.Router{Default: htmlWriterFactory}
+		for feedID, sinkConfig := range config.Sinks {
+			output, err := sinkConfig.New(nil)
+			check(err)
+			router.Sink(feedID, output, sinkConfig.Media)
+		}
+
+		htmlWriterFactory = router
+	}
 
 	aggregator := &feed.Aggregator{
 		Executor:          executor,
 		SubStorage:        store,
-		HTMLWriterFactory: feed.TelegramHTML{Sender: bot},
+		History:           store,
+		HTMLWriterFactory: htmlWriterFactory,
 		UpdateInterval:    config.Interval.Duration,
 		Metrics:           metricsRegistry.WithPrefix("aggregator"),
 	}
 
-	initRedditVendor(ctx, metricsRegistry, aggregator, mediam, store, config.Reddit)
-	initDvachVendors(aggregator, mediam, config.Dvach.Usercode)
+	initRedditVendor(ctx, metricsRegistry, aggregator, mediam, resolvers, store, config.Reddit)
+	initDvachVendors(aggregator, mediam, resolvers, config.Dvach.Usercode)
 
 	listener, err := (&feed.CommandListener{
-		Context:    ctx,
-		Aggregator: aggregator,
-		Management: feed.NewSupervisorManagement(bot, config.Telegram.Supervisor),
-		Aliases:    config.Telegram.Aliases,
-		GitCommit:  GitCommit,
+		Context:     ctx,
+		Aggregator:  aggregator,
+		Management:  feed.NewSupervisorManagement(bot, config.Telegram.Supervisor),
+		Aliases:     config.Telegram.Aliases,
+		GitCommit:   GitCommit,
+		DedupScopes: store,
+		Blobs:       store,
+		Breakers:    breakers,
 	}).Init(ctx)
 	check(err)
 	defer listener.Close()
@@ -206,7 +442,7 @@ func main() {
 	flu.AwaitSignal()
 }
 
-func initRedditVendor(ctx context.Context, metrics metrics.Registry, aggregator *feed.Aggregator, mediam *feed.MediaManager, sqlite3 *feed.SQLStorage, config *reddit.Config) error {
+func initRedditVendor(ctx context.Context, metrics metrics.Registry, aggregator *feed.Aggregator, mediam *feed.MediaManager, resolvers *resolver.Registry, sqlite3 *feed.SQLStorage, config *reddit.Config) error {
 	if config == nil {
 		return nil
 	}
@@ -220,25 +456,24 @@ func initRedditVendor(ctx context.Context, metrics metrics.Registry, aggregator
 		return errors.Wrap(err, "init reddit store")
 	}
 
-	viddit := &common.Viddit{
-		Client:        fluhttp.NewClient(nil),
-		Clock:         flu.DefaultClock,
-		ResetInterval: 20 * time.Minute,
-	}
+	client := reddit.NewClient(nil, config, GitCommit)
+	resolvers.Client("preview.redd.it", client.Client)
 
 	aggregator.Vendor("subreddit", &reddit.SubredditFeed{
-		Client:       reddit.NewClient(nil, config, GitCommit),
+		Client:       client,
 		Store:        store,
 		MediaManager: mediam,
-		Viddit:       viddit,
 		Metrics:      metrics.WithPrefix("subreddit"),
 	})
 
 	return nil
 }
 
-func initDvachVendors(aggregator *feed.Aggregator, mediam *feed.MediaManager, usercode string) {
+func initDvachVendors(aggregator *feed.Aggregator, mediam *feed.MediaManager, resolvers *resolver.Registry, usercode string) {
 	client := dvach.NewClient(nil, usercode)
+	if host, err := url.Parse(dvach.Host); err == nil {
+		resolvers.Client(host.Hostname(), client.Client)
+	}
 
 	aggregator.Vendor("2ch/catalog", &dvach.CatalogFeed{
 		Client:       client,
@@ -251,6 +486,19 @@ func initDvachVendors(aggregator *feed.Aggregator, mediam *feed.MediaManager, us
 	})
 }
 
+func logDryRun(request *botapi.Request) {
+	text := request.Text()
+	for _, media := range request.Media() {
+		if media.URL != "" {
+			text += fmt.Sprintf("\n[%s] %s", media.Type, media.URL)
+		} else {
+			text += fmt.Sprintf("\n[%s] %s (%s, %d bytes)", media.Type, media.Filename, media.MIMEType, len(media.Data))
+		}
+	}
+
+	log.Printf("[dry-run > %d] %s:\n%s", request.ChatID(), request.Method, text)
+}
+
 func check(err error) error {
 	if err != nil {
 		panic(err)
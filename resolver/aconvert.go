@@ -28,6 +28,10 @@ type Aconvert struct {
 	*aconvert.Client
 }
 
+func (a Aconvert) Name() string {
+	return "aconvert"
+}
+
 func (a Aconvert) MIMETypes() []string {
 	return AconvertMIMETypeArray
 }
@@ -0,0 +1,326 @@
+package resolver
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/vendors/common"
+	"github.com/pkg/errors"
+)
+
+// DASHMIMEType is the MIME type of MPEG-DASH playlists.
+const DASHMIMEType = "application/dash+xml"
+
+// DASHTrack is a representation of a DASH playlist.
+type DASHTrack struct {
+	URL       string
+	Bandwidth int64
+	Width     int
+	Height    int
+}
+
+// Size estimates the track size for the duration in seconds.
+func (t DASHTrack) Size(duration float64) int64 {
+	return int64(float64(t.Bandwidth) * duration / 8)
+}
+
+// DASHPlaylist contains video and audio tracks of a DASH playlist.
+type DASHPlaylist struct {
+	// Duration is the duration in seconds.
+	Duration float64
+	Video    []DASHTrack
+	Audio    []DASHTrack
+}
+
+// Select chooses the best video and audio tracks which fit maxSize together.
+// Audio is nil if the playlist has no audio tracks. Sizes are not checked if maxSize is not positive.
+func (p *DASHPlaylist) Select(maxSize int64) (video, audio *DASHTrack, err error) {
+	for i := range p.Audio {
+		track := &p.Audio[i]
+		if audio == nil || track.Bandwidth > audio.Bandwidth {
+			audio = track
+		}
+	}
+
+	var audioSize int64
+	if audio != nil {
+		audioSize = audio.Size(p.Duration)
+	}
+
+	for i := range p.Video {
+		track := &p.Video[i]
+		if maxSize > 0 && track.Size(p.Duration)+audioSize > maxSize {
+			continue
+		}
+
+		if video == nil || track.Height > video.Height ||
+			track.Height == video.Height && track.Bandwidth > video.Bandwidth {
+			video = track
+		}
+	}
+
+	if video == nil {
+		return nil, nil, errors.Errorf("failed to find suitable video track among %d", len(p.Video))
+	}
+
+	return video, audio, nil
+}
+
+func (p *DASHPlaylist) Handle(resp *http.Response) error {
+	defer resp.Body.Close()
+	mpd := new(struct {
+		Duration string `xml:"mediaPresentationDuration,attr"`
+		BaseURL  string `xml:"BaseURL"`
+		Periods  []struct {
+			AdaptationSets []struct {
+				ContentType     string `xml:"contentType,attr"`
+				MIMEType        string `xml:"mimeType,attr"`
+				Representations []struct {
+					MIMEType  string `xml:"mimeType,attr"`
+					Bandwidth int64  `xml:"bandwidth,attr"`
+					Width     int    `xml:"width,attr"`
+					Height    int    `xml:"height,attr"`
+					BaseURL   string `xml:"BaseURL"`
+				} `xml:"Representation"`
+			} `xml:"AdaptationSet"`
+		} `xml:"Period"`
+	})
+
+	if err := xml.NewDecoder(resp.Body).Decode(mpd); err != nil {
+		return errors.Wrap(err, "decode playlist")
+	}
+
+	base := resp.Request.URL
+	if mpd.BaseURL != "" {
+		if u, err := base.Parse(strings.TrimSpace(mpd.BaseURL)); err == nil {
+			base = u
+		}
+	}
+
+	p.Duration = parseISO8601Duration(mpd.Duration)
+	for _, period := range mpd.Periods {
+		for _, set := range period.AdaptationSets {
+			for _, rep := range set.Representations {
+				u, err := base.Parse(strings.TrimSpace(rep.BaseURL))
+				if rep.BaseURL == "" || err != nil {
+					continue
+				}
+
+				track := DASHTrack{URL: u.String(), Bandwidth: rep.Bandwidth, Width: rep.Width, Height: rep.Height}
+				switch contentType(set.ContentType, set.MIMEType, rep.MIMEType) {
+				case "video":
+					p.Video = append(p.Video, track)
+				case "audio":
+					p.Audio = append(p.Audio, track)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// contentType returns the first non-empty content type of the arguments which may be MIME types.
+func contentType(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return strings.SplitN(value, "/", 2)[0]
+		}
+	}
+
+	return ""
+}
+
+var iso8601DurationRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISO8601Duration parses durations like "PT1M2.5S" to seconds. Zero is returned for invalid durations.
+func parseISO8601Duration(value string) float64 {
+	groups := iso8601DurationRegexp.FindStringSubmatch(value)
+	if groups == nil {
+		return 0
+	}
+
+	duration := 0.
+	for i, unit := range []float64{86400, 3600, 60, 1} {
+		if groups[i+1] != "" {
+			value, _ := strconv.ParseFloat(groups[i+1], 64)
+			duration += value * unit
+		}
+	}
+
+	return duration
+}
+
+func getDASHPlaylist(ctx context.Context, client *fluhttp.Client, url string) (*DASHPlaylist, error) {
+	playlist := new(DASHPlaylist)
+	if err := client.GET(url).
+		Context(ctx).
+		Execute().
+		CheckStatus(http.StatusOK).
+		HandleResponse(playlist).
+		Error; err != nil {
+		return nil, errors.Wrap(err, "get playlist")
+	}
+
+	return playlist, nil
+}
+
+// VRedditURL is the base URL of reddit-hosted videos.
+var VRedditURL = "https://v.redd.it"
+
+// VReddit resolves reddit-hosted videos via their DASH playlists.
+// Videos with sound are resolved to the playlist if the media manager has a converter for DASHMIMEType.
+// The best video track, which has no sound, is used as a fallback in case the playlist can not be muxed.
+type VReddit struct {
+	*feed.MediaRef
+}
+
+func (r *VReddit) GetClient() *fluhttp.Client {
+	return nil
+}
+
+// ResolveURL is not used since VReddit implements feed.MediaCandidateResolver.
+func (r *VReddit) ResolveURL(context.Context, *fluhttp.Client, string, int64) (string, error) {
+	return "", errors.New("v.redd.it resolves media candidates only")
+}
+
+func (r *VReddit) Resolve(ctx context.Context, client *fluhttp.Client, rawURL string) (*feed.MediaResolution, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse url")
+	}
+
+	id := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)[0]
+	if id == "" {
+		return nil, errors.Errorf("no video id in %s", rawURL)
+	}
+
+	playlistURL := VRedditURL + "/" + id + "/DASHPlaylist.mpd"
+	playlist, err := getDASHPlaylist(ctx, client, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := telegram.Video.AttachMaxSize()
+	video, audio, err := playlist.Select(maxSize)
+	if err != nil {
+		// the video may still be downscaled
+		if video, audio, err = playlist.Select(0); err != nil {
+			return nil, err
+		}
+	}
+
+	duration := int(playlist.Duration + 0.5)
+	candidates := make([]feed.MediaCandidate, 0, 2)
+	if _, ok := r.MediaRef.Manager.Converters[DASHMIMEType]; audio != nil && ok {
+		candidates = append(candidates, feed.MediaCandidate{
+			URL: playlistURL,
+			MediaMetadata: feed.MediaMetadata{
+				MIMEType: DASHMIMEType,
+				Width:    video.Width,
+				Height:   video.Height,
+				Duration: duration,
+			},
+			Quality:       1,
+			EstimatedSize: video.Size(playlist.Duration) + audio.Size(playlist.Duration),
+		})
+	}
+
+	candidates = append(candidates, feed.MediaCandidate{
+		URL: video.URL,
+		MediaMetadata: feed.MediaMetadata{
+			Width:    video.Width,
+			Height:   video.Height,
+			Duration: duration,
+		},
+		EstimatedSize: video.Size(playlist.Duration),
+	})
+
+	return &feed.MediaResolution{Candidates: candidates}, nil
+}
+
+// DASH downloads the best video and audio tracks of DASH playlists and muxes them with ffmpeg.
+type DASH struct {
+	FFmpeg *FFmpeg
+}
+
+func (d DASH) Name() string {
+	return "dash"
+}
+
+func (d DASH) MIMETypes() []string {
+	return []string{DASHMIMEType}
+}
+
+func (d DASH) Convert(ctx context.Context, ref *feed.MediaRef) (format.MediaRef, error) {
+	client := ref.GetClient()
+	if client == nil {
+		client = ref.Manager.DefaultClient
+	}
+
+	playlist, err := getDASHPlaylist(ctx, client, ref.ResolvedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := telegram.Video.AttachMaxSize()
+	video, audio, err := playlist.Select(maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	downloader := feed.NewMediaClient(client, ref.Manager.CURL, ref.Manager.Retries, ref.Manager.SizeBounds[1])
+	videoFile, err := allocFile(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := downloader.Download(ctx, video.URL, videoFile); err != nil {
+		return nil, errors.Wrap(err, "download video")
+	}
+
+	output := videoFile
+	if audio != nil {
+		audioFile, err := allocFile(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := downloader.Download(ctx, audio.URL, audioFile); err != nil {
+			return nil, errors.Wrap(err, "download audio")
+		}
+
+		if output, err = allocFile(ref); err != nil {
+			return nil, err
+		}
+
+		if err := d.FFmpeg.Mux(ctx, videoFile.Path(), audioFile.Path(), output.Path()); err != nil {
+			return nil, err
+		}
+	}
+
+	if stat, err := os.Stat(output.Path()); err != nil {
+		return nil, errors.Wrap(err, "stat output")
+	} else if maxSize > 0 && stat.Size() > maxSize {
+		// the video track candidate is used instead
+		return nil, errors.Errorf("muxed size %dMb is too large", stat.Size()>>20)
+	}
+
+	if ref.Dedup {
+		if err := ref.Manager.Dedup.Check(ctx, ref.FeedID, ref.URL, "video/mp4", output); err != nil {
+			return nil, err
+		}
+	}
+
+	return common.NewResolvedMediaRef("video/mp4", output), nil
+}
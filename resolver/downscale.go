@@ -0,0 +1,246 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/metrics"
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/vendors/common"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+var (
+	// MaxPhotoDimensionSum is the maximum sum of photo width and height accepted by Telegram.
+	MaxPhotoDimensionSum = 10000
+	// MaxPhotoAspectRatio is the maximum ratio of photo sides accepted by Telegram.
+	// Photos which are longer are sent as documents.
+	MaxPhotoAspectRatio = 20.0
+	// DocumentMIMEType is used for sending media as a document.
+	DocumentMIMEType = "application/octet-stream"
+)
+
+// Downscaler re-encodes images and videos which do not fit Telegram limits.
+// Videos are transcoded only if FFmpeg is set.
+type Downscaler struct {
+	FFmpeg *FFmpeg
+	// Metrics is used for reporting downscale_ok and downscale_err counters by downscale path. Optional.
+	Metrics metrics.Registry
+}
+
+func (d *Downscaler) CanDownscale(mimeType string, size int64) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png":
+		return true
+	case "video/mp4":
+		return d.FFmpeg != nil && size > telegram.Video.AttachMaxSize()
+	default:
+		return false
+	}
+}
+
+func (d *Downscaler) Downscale(ctx context.Context, ref *feed.MediaRef, mimeType string, blob format.Blob, size int64) (format.MediaRef, error) {
+	var (
+		result format.MediaRef
+		path   string
+		err    error
+	)
+
+	switch mimeType {
+	case "image/jpeg", "image/png":
+		result, path, err = d.downscaleImage(ref, mimeType, blob, size)
+	case "video/mp4":
+		if size <= telegram.Video.AttachMaxSize() || d.FFmpeg == nil {
+			return nil, nil
+		}
+
+		path = "video"
+		result, err = d.downscaleVideo(ctx, ref, blob)
+	default:
+		return nil, nil
+	}
+
+	if path != "" && d.Metrics != nil {
+		labels := metrics.Labels{
+			"feed_id", feed.PrintID(ref.FeedID),
+			"mime_type", mimeType,
+			"path", path,
+		}
+
+		if err != nil {
+			d.Metrics.Counter("downscale_err", labels).Inc()
+		} else {
+			d.Metrics.Counter("downscale_ok", labels).Inc()
+		}
+	}
+
+	return result, err
+}
+
+// downscaleImage scales down images which are too large for a photo.
+// Images which can not be decoded are left as is if their size fits the limits.
+func (d *Downscaler) downscaleImage(ref *feed.MediaRef, mimeType string, blob format.Blob, size int64) (format.MediaRef, string, error) {
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "read")
+	}
+
+	defer flu.Close(reader)
+	maxSize := telegram.Photo.AttachMaxSize()
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		if size <= maxSize {
+			return nil, "", nil
+		}
+
+		return nil, "image", errors.Wrap(err, "decode image config")
+	}
+
+	width, height := config.Width, config.Height
+	if float64(max(width, height))/float64(min(width, height)) > MaxPhotoAspectRatio {
+		if size > telegram.Document.AttachMaxSize() {
+			return nil, "document", errors.Errorf("size %dMb is too large for a document", size>>20)
+		}
+
+		return common.NewResolvedMediaRef(DocumentMIMEType, blob), "document", nil
+	}
+
+	if width+height <= MaxPhotoDimensionSum && size <= maxSize {
+		return nil, "", nil
+	}
+
+	img, err := decodeImage(mimeType, blob)
+	if err != nil {
+		return nil, "image", err
+	}
+
+	scale := math.Min(1, float64(MaxPhotoDimensionSum)/float64(width+height))
+	for i := 0; i < 5; i++ {
+		data, err := encodeJPEG(img, scale)
+		if err != nil {
+			return nil, "image", err
+		}
+
+		if int64(len(data)) <= maxSize {
+			out, err := ref.Manager.Storage.Alloc()
+			if err != nil {
+				return nil, "image", errors.Wrap(err, "create blob")
+			}
+
+			if err := flu.Copy(flu.IO{R: bytes.NewReader(data)}, out); err != nil {
+				return nil, "image", errors.Wrap(err, "write blob")
+			}
+
+			return common.NewResolvedMediaRef("image/jpeg", out), "image", nil
+		}
+
+		scale *= 0.75
+	}
+
+	return nil, "image", errors.Errorf("unable to fit %dx%d image into %dMb", width, height, maxSize>>20)
+}
+
+func decodeImage(mimeType string, blob format.Blob) (image.Image, error) {
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	defer flu.Close(reader)
+	var img image.Image
+	if mimeType == "image/png" {
+		img, err = png.Decode(reader)
+	} else {
+		img, err = jpeg.Decode(reader)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "decode image")
+	}
+
+	return img, nil
+}
+
+func encodeJPEG(img image.Image, scale float64) ([]byte, error) {
+	bounds := img.Bounds()
+	width := int(float64(bounds.Dx()) * scale)
+	height := int(float64(bounds.Dy()) * scale)
+	dst := image.NewRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, errors.Wrap(err, "encode jpeg")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (d *Downscaler) downscaleVideo(ctx context.Context, ref *feed.MediaRef, blob format.Blob) (format.MediaRef, error) {
+	input, err := blobFile(ref, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := d.FFmpeg.Duration(ctx, input.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	// leave some room for container overhead and audio
+	maxSize := telegram.Video.AttachMaxSize()
+	bitrate := int64(float64(maxSize*8)*0.9/duration) - 96<<10
+	if bitrate < 100<<10 {
+		return nil, errors.Errorf("video is too long (%.0fs) to fit into %dMb", duration, maxSize>>20)
+	}
+
+	output, err := allocFile(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := fmt.Sprintf("%dk", bitrate>>10)
+	if err := d.FFmpeg.run(ctx, input.Path(), output.Path(), []string{
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-b:v", rate, "-maxrate", rate, "-bufsize", rate,
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:a", "aac", "-b:a", "96k", "-movflags", "+faststart",
+	}); err != nil {
+		return nil, err
+	}
+
+	if stat, err := os.Stat(output.Path()); err != nil {
+		return nil, errors.Wrap(err, "stat output")
+	} else if stat.Size() > maxSize {
+		return nil, errors.Errorf("transcoded size %dMb is too large", stat.Size()>>20)
+	}
+
+	return common.NewResolvedMediaRef("video/mp4", output), nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
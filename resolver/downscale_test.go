@@ -0,0 +1,108 @@
+package resolver_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/resolver"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBlobs struct {
+	dir   string
+	count int
+}
+
+func (b *testBlobs) Alloc() (format.Blob, error) {
+	b.count++
+	return flu.File(filepath.Join(b.dir, fmt.Sprintf("blob%d", b.count))), nil
+}
+
+func testPNG(t *testing.T, dir string, width, height int) format.Blob {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x += 7 {
+		img.SetGray(x, x%height, color.Gray{Y: 0xff})
+	}
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, png.Encode(buf, img))
+	file := flu.File(filepath.Join(dir, fmt.Sprintf("input%dx%d.png", width, height)))
+	assert.Nil(t, ioutil.WriteFile(file.Path(), buf.Bytes(), 0644))
+	return file
+}
+
+func TestDownscaler_CanDownscale(t *testing.T) {
+	downscaler := new(resolver.Downscaler)
+	assert.True(t, downscaler.CanDownscale("image/jpeg", 100<<20))
+	assert.True(t, downscaler.CanDownscale("image/png", 100<<20))
+	assert.False(t, downscaler.CanDownscale("video/mp4", 100<<20))
+	assert.False(t, downscaler.CanDownscale("image/gif", 100<<20))
+
+	downscaler.FFmpeg = new(resolver.FFmpeg)
+	assert.True(t, downscaler.CanDownscale("video/mp4", 100<<20))
+	assert.False(t, downscaler.CanDownscale("video/mp4", 1<<20))
+}
+
+func TestDownscaler_Downscale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hikkabot-downscale-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	downscaler := new(resolver.Downscaler)
+	manager := &feed.MediaManager{Storage: &testBlobs{dir: dir}}
+	newRef := func(mimeType string) *feed.MediaRef {
+		return &feed.MediaRef{Manager: manager, MediaMetadata: feed.MediaMetadata{MIMEType: mimeType}}
+	}
+
+	// fits the limits already
+	ref, err := downscaler.Downscale(ctx, newRef("image/png"), "image/png", testPNG(t, dir, 640, 480), 1<<10)
+	assert.Nil(t, err)
+	assert.Nil(t, ref)
+
+	// too large dimensions are scaled down
+	ref, err = downscaler.Downscale(ctx, newRef("image/png"), "image/png", testPNG(t, dir, 9600, 500), 1<<10)
+	assert.Nil(t, err)
+	media, err := ref.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "image/jpeg", media.MIMEType)
+	reader, err := media.Input.Reader()
+	assert.Nil(t, err)
+	config, err := jpeg.DecodeConfig(reader)
+	flu.Close(reader)
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, config.Width+config.Height, resolver.MaxPhotoDimensionSum)
+
+	// too long photos are sent as documents
+	ref, err = downscaler.Downscale(ctx, newRef("image/png"), "image/png", testPNG(t, dir, 2100, 100), 1<<10)
+	assert.Nil(t, err)
+	media, err = ref.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, resolver.DocumentMIMEType, media.MIMEType)
+
+	// images which can not be decoded are left as is unless they are too large
+	broken := flu.File(filepath.Join(dir, "broken.jpg"))
+	assert.Nil(t, ioutil.WriteFile(broken.Path(), []byte("not an image"), 0644))
+	ref, err = downscaler.Downscale(ctx, newRef("image/jpeg"), "image/jpeg", broken, 1<<10)
+	assert.Nil(t, err)
+	assert.Nil(t, ref)
+	_, err = downscaler.Downscale(ctx, newRef("image/jpeg"), "image/jpeg", broken, 100<<20)
+	assert.NotNil(t, err)
+
+	// videos are not downscaled without ffmpeg
+	ref, err = downscaler.Downscale(ctx, newRef("video/mp4"), "video/mp4", testPNG(t, dir, 1, 1), 100<<20)
+	assert.Nil(t, err)
+	assert.Nil(t, ref)
+}
@@ -0,0 +1,251 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jfk9w-go/flu"
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/vendors/common"
+	"github.com/pkg/errors"
+)
+
+// FFmpegPresets are default ffmpeg output arguments for source MIME types.
+// All presets produce mp4 suitable for Telegram built-in video player.
+// They change only the container and codecs, media which turns out to be too large
+// is downscaled with MediaManager.Downscaler.
+var FFmpegPresets = map[string][]string{
+	"video/webm": {
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:a", "aac", "-movflags", "+faststart",
+	},
+	"image/gif": {
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-an", "-movflags", "+faststart",
+	},
+}
+
+// FFmpeg converts media to mp4 locally.
+// Temporary files are allocated in MediaManager.Storage.
+type FFmpeg struct {
+	Binary string
+	// Presets override FFmpegPresets.
+	Presets     map[string][]string
+	RateLimiter flu.RateLimiter
+}
+
+func (f *FFmpeg) preset(mimeType string) []string {
+	if preset, ok := f.Presets[mimeType]; ok {
+		return preset
+	}
+
+	return FFmpegPresets[mimeType]
+}
+
+func (f *FFmpeg) Name() string {
+	return "ffmpeg"
+}
+
+func (f *FFmpeg) MIMETypes() []string {
+	mimeTypes := make([]string, 0, len(FFmpegPresets))
+	for mimeType := range FFmpegPresets {
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+
+	return mimeTypes
+}
+
+func (f *FFmpeg) Convert(ctx context.Context, ref *feed.MediaRef) (format.MediaRef, error) {
+	input, err := allocFile(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ref.Download(ctx, input); err != nil {
+		return nil, errors.Wrap(err, "download")
+	}
+
+	output, err := allocFile(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.run(ctx, input.Path(), output.Path(), f.preset(ref.MIMEType)); err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(output.Path())
+	if err != nil {
+		return nil, errors.Wrap(err, "stat output")
+	}
+
+	if ref.Dedup {
+		if err := ref.Manager.Dedup.Check(ctx, ref.FeedID, ref.URL, "video/mp4", output); err != nil {
+			return nil, err
+		}
+	}
+
+	if size := stat.Size(); size > telegram.Video.AttachMaxSize() {
+		downscaler := ref.Manager.Downscaler
+		if downscaler == nil || !downscaler.CanDownscale("video/mp4", size) {
+			return nil, errors.Errorf("converted size %dMb is too large", size>>20)
+		}
+
+		return downscaler.Downscale(ctx, ref, "video/mp4", output, size)
+	}
+
+	return common.NewResolvedMediaRef("video/mp4", output), nil
+}
+
+// allocFile allocates a temporary blob which is backed by a local file, so that it can be passed to ffmpeg.
+func allocFile(ref *feed.MediaRef) (flu.File, error) {
+	blob, err := ref.Manager.Storage.Alloc()
+	if err != nil {
+		return "", errors.Wrap(err, "create blob")
+	}
+
+	file, ok := blob.(flu.File)
+	if !ok {
+		return "", errors.Errorf("blob %T is not a file", blob)
+	}
+
+	return file, nil
+}
+
+// blobFile returns the blob if it is backed by a local file and copies it into a new one otherwise.
+func blobFile(ref *feed.MediaRef, blob format.Blob) (flu.File, error) {
+	if file, ok := blob.(flu.File); ok {
+		return file, nil
+	}
+
+	file, err := allocFile(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if err := flu.Copy(blob, file); err != nil {
+		return "", errors.Wrap(err, "copy blob")
+	}
+
+	return file, nil
+}
+
+func (f *FFmpeg) run(ctx context.Context, input, output string, preset []string) error {
+	if f.RateLimiter != nil {
+		if err := f.RateLimiter.Start(ctx); err != nil {
+			return err
+		}
+
+		defer f.RateLimiter.Complete()
+	}
+
+	args := append([]string{"-v", "error", "-y", "-i", input}, preset...)
+	args = append(args, "-f", "mp4", output)
+	return f.command(ctx, args...)
+}
+
+// Mux combines the video and audio tracks into an mp4 file without transcoding.
+func (f *FFmpeg) Mux(ctx context.Context, video, audio, output string) error {
+	return f.command(ctx, "-v", "error", "-y", "-i", video, "-i", audio,
+		"-map", "0:v:0", "-map", "1:a:0", "-c", "copy", "-f", "mp4", output)
+}
+
+func (f *FFmpeg) command(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, f.Binary, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "run ffmpeg: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// info returns input information printed by ffmpeg.
+func (f *FFmpeg) info(ctx context.Context, path string) []byte {
+	// ffmpeg exits with an error when no output is specified, but prints input info anyway
+	out, _ := exec.CommandContext(ctx, f.Binary, "-hide_banner", "-i", path).CombinedOutput()
+	return out
+}
+
+var (
+	ffmpegDurationRegexp   = regexp.MustCompile(`Duration: (\d+):(\d+):(\d+(\.\d+)?)`)
+	ffmpegDimensionsRegexp = regexp.MustCompile(`Video: .*?, (\d{2,5})x(\d{2,5})`)
+)
+
+// Duration returns media duration in seconds as reported by ffmpeg.
+func (f *FFmpeg) Duration(ctx context.Context, path string) (float64, error) {
+	return parseDuration(f.info(ctx, path))
+}
+
+func parseDuration(info []byte) (float64, error) {
+	groups := ffmpegDurationRegexp.FindSubmatch(info)
+	if len(groups) < 4 {
+		return 0, errors.New("unable to detect duration")
+	}
+
+	hours, _ := strconv.Atoi(string(groups[1]))
+	minutes, _ := strconv.Atoi(string(groups[2]))
+	seconds, _ := strconv.ParseFloat(string(groups[3]), 64)
+	duration := float64(hours*3600+minutes*60) + seconds
+	if duration <= 0 {
+		return 0, errors.New("zero duration")
+	}
+
+	return duration, nil
+}
+
+// ThumbnailSize is the maximum thumbnail side accepted by Telegram.
+var ThumbnailSize = 320
+
+// Probe fills in missing video dimensions and duration and generates a JPEG thumbnail from the first frame.
+func (f *FFmpeg) Probe(ctx context.Context, blob format.Blob, metadata *feed.MediaMetadata) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "hikkabot-probe")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input")
+	if err := flu.Copy(blob, flu.File(input)); err != nil {
+		return nil, errors.Wrap(err, "write input")
+	}
+
+	info := f.info(ctx, input)
+	if metadata.Duration <= 0 {
+		if duration, err := parseDuration(info); err == nil {
+			metadata.Duration = int(math.Ceil(duration))
+		}
+	}
+
+	if metadata.Width <= 0 || metadata.Height <= 0 {
+		if groups := ffmpegDimensionsRegexp.FindSubmatch(info); len(groups) == 3 {
+			metadata.Width, _ = strconv.Atoi(string(groups[1]))
+			metadata.Height, _ = strconv.Atoi(string(groups[2]))
+		}
+	}
+
+	output := filepath.Join(dir, "thumb.jpg")
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", ThumbnailSize, ThumbnailSize)
+	if err := f.command(ctx, "-v", "error", "-y", "-i", input,
+		"-vf", scale, "-frames:v", "1", "-q:v", "5", output); err != nil {
+		return nil, errors.Wrap(err, "thumbnail")
+	}
+
+	thumbnail, err := ioutil.ReadFile(output)
+	if err != nil {
+		return nil, errors.Wrap(err, "read thumbnail")
+	}
+
+	return thumbnail, nil
+}
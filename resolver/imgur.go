@@ -4,17 +4,36 @@ import (
 	"bufio"
 	"context"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 
+	"github.com/jfk9w-go/flu"
 	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w/hikkabot/feed"
 	"github.com/pkg/errors"
 )
 
-var ImgurRegexp = regexp.MustCompile(`.*?(<link rel="image_src"\s+href="|<meta property="og:video"\s+content=")(.*?)".*`)
+var (
+	ImgurRegexp      = regexp.MustCompile(`.*?(<link rel="image_src"\s+href="|<meta property="og:video"\s+content=")(.*?)".*`)
+	ImgurAlbumRegexp = regexp.MustCompile(`^/(?:a|gallery|t/[^/]+)/(?:[^/]*-)?([0-9A-Za-z]+)/?$`)
+	ImgurAPIURL      = "https://api.imgur.com"
+)
+
+// ImgurRule returns the rule for imgur.com links.
+// Albums are resolved with Imgur API only if the client ID is set.
+func ImgurRule(clientID string) Rule {
+	return Rule{
+		Name:  "imgur",
+		Hosts: []string{"imgur.com", "www.imgur.com", "m.imgur.com"},
+		New:   func(*feed.MediaRef) feed.MediaResolver { return &Imgur{ClientID: clientID} },
+	}
+}
 
 type Imgur struct {
-	URL string
+	// ClientID is the Imgur API client ID. Albums are not resolved if it is empty.
+	ClientID string
+	URL      string
 }
 
 func (r *Imgur) GetClient() *fluhttp.Client {
@@ -36,7 +55,7 @@ func (r *Imgur) ResolveURL(ctx context.Context, client *fluhttp.Client, url stri
 
 func (r *Imgur) Handle(resp *http.Response) error {
 	defer resp.Body.Close()
-	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
 		return errors.New("not an html")
 	}
 
@@ -52,3 +71,88 @@ func (r *Imgur) Handle(resp *http.Response) error {
 
 	return errors.New("unable to find URL")
 }
+
+// IsAlbum checks if the URL points to an album or a gallery post.
+func (r *Imgur) IsAlbum(rawURL string) bool {
+	_, ok := parseImgurAlbumURL(rawURL)
+	return ok && r.ClientID != ""
+}
+
+// Resolve resolves album and gallery post URLs to all their images and videos.
+// Gallery posts are albums in terms of Imgur API.
+// Other URLs are resolved with ResolveURL.
+func (r *Imgur) Resolve(ctx context.Context, client *fluhttp.Client, rawURL string) (*feed.MediaResolution, error) {
+	id, ok := parseImgurAlbumURL(rawURL)
+	if !ok || r.ClientID == "" {
+		url, err := r.ResolveURL(ctx, client, rawURL, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return &feed.MediaResolution{Candidates: []feed.MediaCandidate{{URL: url}}}, nil
+	}
+
+	resp := new(struct {
+		Data []struct {
+			Link    string `json:"link"`
+			Type    string `json:"type"`
+			Size    int64  `json:"size"`
+			Width   int    `json:"width"`
+			Height  int    `json:"height"`
+			MP4     string `json:"mp4"`
+			MP4Size int64  `json:"mp4_size"`
+		} `json:"data"`
+	})
+
+	if err := client.GET(ImgurAPIURL+"/3/album/"+id+"/images").
+		Context(ctx).
+		SetHeader("Authorization", "Client-ID "+r.ClientID).
+		Execute().
+		CheckStatus(http.StatusOK).
+		DecodeBody(flu.JSON{Value: resp}).
+		Error; err != nil {
+		return nil, errors.Wrapf(err, "get %s", id)
+	}
+
+	album := make([][]feed.MediaCandidate, 0, len(resp.Data))
+	for _, image := range resp.Data {
+		candidate := feed.MediaCandidate{
+			URL: image.Link,
+			MediaMetadata: feed.MediaMetadata{
+				MIMEType: image.Type,
+				Size:     image.Size,
+				Width:    image.Width,
+				Height:   image.Height,
+			},
+		}
+
+		// animated images are available as mp4 as well
+		if image.MP4 != "" {
+			candidate.URL = image.MP4
+			candidate.MIMEType = "video/mp4"
+			candidate.Size = image.MP4Size
+		}
+
+		if candidate.URL == "" {
+			continue
+		}
+
+		album = append(album, []feed.MediaCandidate{candidate})
+	}
+
+	return &feed.MediaResolution{Album: album}, nil
+}
+
+func parseImgurAlbumURL(rawURL string) (id string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+
+	groups := ImgurAlbumRegexp.FindStringSubmatch(u.Path)
+	if len(groups) != 2 {
+		return "", false
+	}
+
+	return groups[1], true
+}
@@ -0,0 +1,322 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// OpenGraphProperties are meta tag properties and names containing media URLs in the order of preference.
+var OpenGraphProperties = []string{
+	"og:video:secure_url",
+	"og:video:url",
+	"og:video",
+	"twitter:player:stream",
+	"og:image:secure_url",
+	"og:image:url",
+	"og:image",
+	"twitter:image",
+	"twitter:image:src",
+}
+
+// ErrPrivateAddress is returned for URLs which point to loopback, private or link-local addresses.
+var ErrPrivateAddress = errors.New("private address")
+
+// privateNetworks are address ranges which are not checked by net.IP methods.
+var privateNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// OpenGraph resolves web pages to media found in OpenGraph and Twitter card meta tags or oEmbed.
+// It is used as feed.MediaManager.PageResolver, so only URLs which turn out to point to an HTML page
+// (according to the HEAD or ranged GET request made for every media) are resolved.
+// Resolving a page takes a GET request for the page head and another one for oEmbed JSON if the page links it.
+// Candidates are returned in the order of preference without requesting them,
+// and MediaManager requests their metadata one by one until one fits.
+// Pages and candidates on private addresses are rejected unless AllowPrivate is set.
+// Page requests are additionally checked when connecting and on every redirect,
+// so that hosts which resolve to private addresses after the check are not reached either.
+type OpenGraph struct {
+	AllowPrivate bool
+}
+
+func (r OpenGraph) ResolvePage(ctx context.Context, client *fluhttp.Client, rawURL string) ([]feed.MediaCandidate, error) {
+	public := make(map[string]error)
+	if err := r.checkHost(ctx, public, rawURL); err != nil {
+		return nil, err
+	}
+
+	client = r.guard(client, public)
+
+	page := new(openGraphPage)
+	if err := client.GET(rawURL).
+		Context(ctx).
+		Execute().
+		CheckStatus(http.StatusOK).
+		HandleResponse(page).
+		Error; err != nil {
+		return nil, errors.Wrap(err, "get page")
+	} else if !page.html {
+		return nil, errors.New("not an html page")
+	}
+
+	urls := page.candidates()
+	if page.oembed != "" && r.checkHost(ctx, public, page.oembed) == nil {
+		oembed := new(struct {
+			Type         string `json:"type"`
+			URL          string `json:"url"`
+			ThumbnailURL string `json:"thumbnail_url"`
+		})
+
+		if err := client.GET(page.oembed).
+			Context(ctx).
+			Execute().
+			CheckStatus(http.StatusOK).
+			DecodeBody(flu.JSON{Value: oembed}).
+			Error; err == nil {
+			if oembed.Type == "photo" {
+				urls = page.append(urls, oembed.URL)
+			}
+
+			urls = page.append(urls, oembed.ThumbnailURL)
+		}
+	}
+
+	candidates := make([]feed.MediaCandidate, 0, len(urls))
+	for _, url := range urls {
+		if r.checkHost(ctx, public, url) == nil {
+			candidates = append(candidates, feed.MediaCandidate{URL: url})
+		}
+	}
+
+	return candidates, nil
+}
+
+// guard returns a copy of the client which refuses to connect to private addresses
+// and checks every redirect target.
+func (r OpenGraph) guard(client *fluhttp.Client, public map[string]error) *fluhttp.Client {
+	if r.AllowPrivate {
+		return client
+	}
+
+	httpClient := *client.Client
+	httpClient.Transport = publicTransport
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.Errorf("unsupported redirect scheme: %s", req.URL.Scheme)
+		}
+
+		return r.checkHost(req.Context(), public, req.URL.String())
+	}
+
+	guarded := *client
+	guarded.Client = &httpClient
+	return &guarded
+}
+
+// publicTransport is used for page requests. It checks the address right before connecting to it.
+var publicTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return errors.Wrap(err, "split host port")
+			}
+
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return errors.Wrapf(ErrPrivateAddress, "dial %s", address)
+			}
+
+			return nil
+		},
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          10,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+}
+
+// checkHost checks that the URL host does not resolve to private addresses.
+// Results are cached in checked by host.
+func (r OpenGraph) checkHost(ctx context.Context, checked map[string]error, rawURL string) error {
+	if r.AllowPrivate {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Wrap(err, "parse url")
+	}
+
+	host := u.Hostname()
+	if err, ok := checked[host]; ok {
+		return err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		err = errors.Wrapf(err, "lookup %s", host)
+	} else {
+		for _, addr := range addrs {
+			if isPrivateIP(addr.IP) {
+				err = errors.Wrapf(ErrPrivateAddress, "%s resolves to %s", host, addr.IP)
+				break
+			}
+		}
+	}
+
+	checked[host] = err
+	return err
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCIDRs(values ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(values))
+	for i, value := range values {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			panic(err)
+		}
+
+		networks[i] = network
+	}
+
+	return networks
+}
+
+type openGraphPage struct {
+	base   *url.URL
+	html   bool
+	meta   map[string]string
+	images []string
+	oembed string
+}
+
+func (p *openGraphPage) Handle(resp *http.Response) error {
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+
+	p.html = true
+	p.base = resp.Request.URL
+	p.meta = make(map[string]string)
+	tokenizer := html.NewTokenizer(resp.Body)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return nil
+		case html.EndTagToken:
+			if token := tokenizer.Token(); token.Data == "head" {
+				return nil
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			attrs := format.HTMLAttributes(token.Attr)
+			switch token.Data {
+			case "body":
+				return nil
+			case "meta":
+				key := attrs.Get("property")
+				if key == "" {
+					key = attrs.Get("name")
+				}
+
+				if content := attrs.Get("content"); content != "" {
+					if _, ok := p.meta[key]; !ok {
+						p.meta[key] = content
+					}
+				}
+			case "link":
+				href := attrs.Get("href")
+				switch {
+				case href == "":
+				case attrs.Get("rel") == "image_src":
+					p.images = append(p.images, href)
+				case attrs.Get("type") == "application/json+oembed" && p.oembed == "":
+					p.oembed = p.resolve(href)
+				}
+			}
+		}
+	}
+}
+
+// candidates returns unique media URLs in the order of preference.
+func (p *openGraphPage) candidates() []string {
+	candidates := make([]string, 0)
+	for _, property := range OpenGraphProperties {
+		candidates = p.append(candidates, p.meta[property])
+	}
+
+	for _, image := range p.images {
+		candidates = p.append(candidates, image)
+	}
+
+	return candidates
+}
+
+// append adds the resolved URL to candidates if it is valid and is not there yet.
+func (p *openGraphPage) append(candidates []string, value string) []string {
+	value = p.resolve(value)
+	if value == "" {
+		return candidates
+	}
+
+	for _, candidate := range candidates {
+		if candidate == value {
+			return candidates
+		}
+	}
+
+	return append(candidates, value)
+}
+
+// resolve makes the URL absolute. Empty string is returned for invalid URLs.
+func (p *openGraphPage) resolve(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return ""
+	}
+
+	if p.base != nil {
+		u = p.base.ResolveReference(u)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+
+	return u.String()
+}
@@ -0,0 +1,93 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/resolver"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenGraph_ResolvePage(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	media := func(mimeType string, size int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", mimeType)
+			w.Header().Set("Content-Length", fmt.Sprint(size))
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	page := func(head string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprintf(w, "<html><head>%s</head><body></body></html>", head)
+		}
+	}
+
+	mux.Handle("/player", page(""))
+	mux.Handle("/large.mp4", media("video/mp4", 5000))
+	mux.Handle("/small.jpg", media("image/jpeg", 500))
+	mux.Handle("/photo.png", media("image/png", 500))
+	mux.Handle("/post", page(`
+		<meta property="og:video" content="/player">
+		<meta property="og:video:secure_url" content="/large.mp4">
+		<meta name="twitter:image" content="/small.jpg">`))
+	mux.HandleFunc("/oembed.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"type": "photo", "url": "%s/photo.png"}`, server.URL)
+	})
+	mux.Handle("/photo", page(`<link rel="alternate" type="application/json+oembed" href="/oembed.json">`))
+	mux.Handle("/empty", page(`<title>Nothing here</title>`))
+
+	client := fluhttp.NewClient(nil)
+	resolve := func(path string) ([]string, error) {
+		candidates, err := resolver.OpenGraph{AllowPrivate: true}.ResolvePage(context.Background(), client, server.URL+path)
+		urls := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			urls[i] = candidate.URL
+		}
+
+		return urls, err
+	}
+
+	// candidates are not requested
+	urls, err := resolve("/post")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{server.URL + "/large.mp4", server.URL + "/player", server.URL + "/small.jpg"}, urls)
+
+	urls, err = resolve("/photo")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{server.URL + "/photo.png"}, urls)
+
+	urls, err = resolve("/empty")
+	assert.Nil(t, err)
+	assert.Empty(t, urls)
+
+	_, err = resolve("/large.mp4")
+	assert.NotNil(t, err)
+
+	// private addresses are rejected by default
+	_, err = resolver.OpenGraph{}.ResolvePage(context.Background(), client, server.URL+"/post")
+	assert.True(t, errors.Is(err, resolver.ErrPrivateAddress))
+
+	registry, err := resolver.NewRegistry(resolver.Config{})
+	assert.Nil(t, err)
+	assert.Equal(t, resolver.OpenGraph{}, registry.PageResolver())
+	ref := &feed.MediaRef{URL: "https://example.com/post/1"}
+	registry.Setup(ref)
+	assert.Equal(t, feed.DummyMediaResolver{}, ref.MediaResolver)
+	assert.Equal(t, "", ref.ResolverName)
+
+	registry, err = resolver.NewRegistry(resolver.Config{Disabled: []string{resolver.PageResolverName}})
+	assert.Nil(t, err)
+	assert.Nil(t, registry.PageResolver())
+}
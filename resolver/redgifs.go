@@ -12,7 +12,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-var redGIFsURLTemplate = "https://api.%s.com/v1/gfycats/%s"
+var RedGIFsURLTemplate = "https://api.%s.com/v1/gfycats/%s"
 
 type RedGIFs struct {
 	Site string // either redgifs or gfycat
@@ -33,7 +33,7 @@ func (r RedGIFs) ResolveURL(ctx context.Context, client *fluhttp.Client, url str
 		} `json:"gfyItem"`
 	})
 
-	apiURL := fmt.Sprintf(redGIFsURLTemplate, r.Site, code)
+	apiURL := fmt.Sprintf(RedGIFsURLTemplate, r.Site, code)
 	if err := client.GET(apiURL).
 		Context(ctx).
 		Execute().
@@ -0,0 +1,325 @@
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+)
+
+// Rule selects a resolver for media URLs.
+type Rule struct {
+	// Name is used for disabling the rule in Config.
+	Name string
+	// Hosts are host patterns the URL host must match.
+	// "example.com" matches only the host itself, "*.example.com" matches its subdomains as well
+	// and "*" matches any host.
+	Hosts []string
+	// URL is an optional regular expression the URL must match.
+	URL *regexp.Regexp
+	// Blob forces media to be downloaded instead of being sent by URL.
+	Blob bool
+	// New creates a resolver for the media reference.
+	New func(ref *feed.MediaRef) feed.MediaResolver
+}
+
+func (r Rule) match(host, url string) bool {
+	for _, pattern := range r.Hosts {
+		if matchHost(pattern, host) {
+			return r.URL == nil || r.URL.MatchString(url)
+		}
+	}
+
+	return false
+}
+
+func matchHost(pattern, host string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		domain := pattern[2:]
+		return host == domain || strings.HasSuffix(host, pattern[1:])
+	default:
+		return host == pattern
+	}
+}
+
+// DefaultRules are built-in resolver rules. Rules are matched in order.
+// ImgurRule is added by NewRegistry as it depends on Config.
+var DefaultRules = []Rule{
+	{
+		Name:  "gfycat",
+		Hosts: []string{"gfycat.com", "www.gfycat.com"},
+		Blob:  true,
+		New:   func(*feed.MediaRef) feed.MediaResolver { return RedGIFs{Site: "gfycat"} },
+	},
+	{
+		Name:  "redgifs",
+		Hosts: []string{"redgifs.com", "www.redgifs.com"},
+		Blob:  true,
+		New:   func(*feed.MediaRef) feed.MediaResolver { return RedGIFs{Site: "redgifs"} },
+	},
+	{
+		Name:  "vreddit",
+		Hosts: []string{"v.redd.it"},
+		New:   func(ref *feed.MediaRef) feed.MediaResolver { return &VReddit{MediaRef: ref} },
+	},
+	{
+		Name:  "youtube",
+		Hosts: []string{"youtube.com", "www.youtube.com", "m.youtube.com", "youtu.be"},
+		New:   func(ref *feed.MediaRef) feed.MediaResolver { return &YouTube{MediaRef: ref} },
+	},
+}
+
+// PageResolverName is the name of OpenGraph page resolver which may be disabled in Config.
+const PageResolverName = "opengraph"
+
+// Rewrite replaces media URLs matching the regular expression before resolving.
+// Replace may refer to submatches as in regexp.Regexp.ReplaceAllString.
+type Rewrite struct {
+	URL     string
+	Replace string
+}
+
+// DefaultRewrites are built-in URL rewrites applied before the configured ones.
+var DefaultRewrites = []Rewrite{
+	{URL: `^(https?://([a-z]+\.)?imgur\.com/[^?#]+)\.gifv`, Replace: "${1}.mp4"},
+}
+
+type rewrite struct {
+	regexp  *regexp.Regexp
+	replace string
+}
+
+// ClientConfig describes an HTTP client used for media from a domain.
+type ClientConfig struct {
+	// UserAgent is the User-Agent header value. Optional.
+	UserAgent string
+	// Headers are additional request headers. Optional.
+	Headers map[string]string
+	// Proxy is the proxy URL. Optional.
+	Proxy string
+}
+
+func (c ClientConfig) newClient() (*fluhttp.Client, error) {
+	transport := &http.Transport{}
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse proxy url: %s", c.Proxy)
+		}
+
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	client := fluhttp.NewClient(&http.Client{Transport: transport})
+	if c.UserAgent != "" {
+		client.SetHeader("User-Agent", c.UserAgent)
+	}
+
+	for key, value := range c.Headers {
+		client.SetHeader(key, value)
+	}
+
+	return client, nil
+}
+
+// Config describes Registry configuration.
+type Config struct {
+	// Disabled are names of disabled rules (see DefaultRules).
+	Disabled []string
+	// Rewrites are applied to media URLs after DefaultRewrites.
+	Rewrites []Rewrite
+	// Clients are HTTP clients by domain. Subdomains use clients of the domain.
+	Clients map[string]ClientConfig
+	// ImgurClientID is the Imgur API client ID used for resolving albums. Optional.
+	ImgurClientID string
+}
+
+// Registry sets up resolvers and HTTP clients for media references by URL.
+// References which match no rule are resolved as is. Those which turn out to be web pages
+// are resolved with PageResolver.
+type Registry struct {
+	rules    []Rule
+	rewrites []rewrite
+	clients  map[string]*fluhttp.Client
+	disabled map[string]bool
+	mu       sync.RWMutex
+}
+
+// NewRegistry creates a Registry with DefaultRules, ImgurRule and DefaultRewrites.
+// The rules are matched before DefaultRules.
+func NewRegistry(config Config, rules ...Rule) (*Registry, error) {
+	r := &Registry{
+		clients:  make(map[string]*fluhttp.Client),
+		disabled: make(map[string]bool),
+	}
+
+	for _, name := range config.Disabled {
+		r.disabled[name] = true
+	}
+
+	for _, rw := range append(DefaultRewrites, config.Rewrites...) {
+		if err := r.Rewrite(rw); err != nil {
+			return nil, err
+		}
+	}
+
+	for domain, clientConfig := range config.Clients {
+		client, err := clientConfig.newClient()
+		if err != nil {
+			return nil, errors.Wrapf(err, "client for %s", domain)
+		}
+
+		r.clients[strings.ToLower(domain)] = client
+	}
+
+	for _, rule := range rules {
+		r.Rule(rule)
+	}
+
+	r.Rule(ImgurRule(config.ImgurClientID))
+	for _, rule := range DefaultRules {
+		r.Rule(rule)
+	}
+
+	return r, nil
+}
+
+// PageResolver returns OpenGraph unless it is disabled in Config.
+func (r *Registry) PageResolver() feed.MediaPageResolver {
+	if r.disabled[PageResolverName] {
+		return nil
+	}
+
+	return OpenGraph{}
+}
+
+// Rule adds a rule which is matched after the previously added ones.
+// Rules disabled in Config are ignored.
+func (r *Registry) Rule(rule Rule) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.disabled[rule.Name] {
+		r.rules = append(r.rules, rule)
+	}
+
+	return r
+}
+
+// Rewrite adds a URL rewrite.
+func (r *Registry) Rewrite(config Rewrite) error {
+	re, err := regexp.Compile(config.URL)
+	if err != nil {
+		return errors.Wrapf(err, "compile rewrite: %s", config.URL)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rewrites = append(r.rewrites, rewrite{regexp: re, replace: config.Replace})
+	return nil
+}
+
+// Client sets the HTTP client for the domain unless it is configured in Config.
+func (r *Registry) Client(domain string, client *fluhttp.Client) *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	domain = strings.ToLower(domain)
+	if _, ok := r.clients[domain]; !ok {
+		r.clients[domain] = client
+	}
+
+	return r
+}
+
+// Setup rewrites the reference URL and sets its resolver.
+func (r *Registry) Setup(ref *feed.MediaRef) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rewrite := range r.rewrites {
+		ref.URL = rewrite.regexp.ReplaceAllString(ref.URL, rewrite.replace)
+	}
+
+	host := ""
+	if u, err := url.Parse(ref.URL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+
+	var resolver feed.MediaResolver = feed.DummyMediaResolver{}
+	for _, rule := range r.rules {
+		if rule.match(host, ref.URL) {
+			resolver = rule.New(ref)
+			ref.ResolverName = rule.Name
+			ref.Blob = ref.Blob || rule.Blob
+			break
+		}
+	}
+
+	if client := r.client(host); client != nil {
+		wrapped := clientResolver{MediaResolver: resolver, client: client}
+		switch inner := resolver.(type) {
+		case feed.MediaAlbumResolver:
+			resolver = clientAlbumResolver{clientCandidateResolver{wrapped, inner}, inner}
+		case feed.MediaCandidateResolver:
+			resolver = clientCandidateResolver{wrapped, inner}
+		default:
+			resolver = wrapped
+		}
+	}
+
+	ref.MediaResolver = resolver
+}
+
+func (r *Registry) client(host string) *fluhttp.Client {
+	for domain := host; domain != ""; {
+		if client, ok := r.clients[domain]; ok {
+			return client
+		}
+
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+
+		domain = domain[dot+1:]
+	}
+
+	return nil
+}
+
+// clientResolver overrides the HTTP client of the resolver.
+type clientResolver struct {
+	feed.MediaResolver
+	client *fluhttp.Client
+}
+
+func (r clientResolver) GetClient() *fluhttp.Client {
+	return r.client
+}
+
+// clientCandidateResolver overrides the HTTP client of the candidate resolver.
+type clientCandidateResolver struct {
+	clientResolver
+	candidates feed.MediaCandidateResolver
+}
+
+func (r clientCandidateResolver) Resolve(ctx context.Context, client *fluhttp.Client, url string) (*feed.MediaResolution, error) {
+	return r.candidates.Resolve(ctx, client, url)
+}
+
+// clientAlbumResolver overrides the HTTP client of the album resolver.
+type clientAlbumResolver struct {
+	clientCandidateResolver
+	album feed.MediaAlbumResolver
+}
+
+func (r clientAlbumResolver) IsAlbum(url string) bool {
+	return r.album.IsAlbum(url)
+}
@@ -0,0 +1,60 @@
+package resolver_test
+
+import (
+	"regexp"
+	"testing"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/resolver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Setup(t *testing.T) {
+	registry, err := resolver.NewRegistry(resolver.Config{
+		Disabled: []string{"youtube", "opengraph"},
+		Rewrites: []resolver.Rewrite{{URL: `^http://`, Replace: "https://"}},
+		Clients:  map[string]resolver.ClientConfig{"example.com": {UserAgent: "test"}},
+	})
+	assert.Nil(t, err)
+
+	custom := fluhttp.NewClient(nil)
+	registry.Client("example.com", custom).Client("2ch.hk", custom).Rule(resolver.Rule{
+		Name:  "custom",
+		Hosts: []string{"*.example.org"},
+		URL:   regexp.MustCompile(`/video/`),
+		New:   func(*feed.MediaRef) feed.MediaResolver { return resolver.RedGIFs{Site: "custom"} },
+	})
+
+	setup := func(url string) *feed.MediaRef {
+		ref := &feed.MediaRef{URL: url}
+		registry.Setup(ref)
+		return ref
+	}
+
+	ref := setup("http://i.imgur.com/abc.gifv")
+	assert.Equal(t, "https://i.imgur.com/abc.mp4", ref.URL)
+	assert.Equal(t, feed.DummyMediaResolver{}, ref.MediaResolver)
+
+	ref = setup("https://imgur.com/gallery/abc")
+	assert.IsType(t, new(resolver.Imgur), ref.MediaResolver)
+
+	ref = setup("https://www.redgifs.com/watch/abc")
+	assert.Equal(t, resolver.RedGIFs{Site: "redgifs"}, ref.MediaResolver)
+	assert.True(t, ref.Blob)
+
+	ref = setup("https://youtu.be/abc")
+	assert.Equal(t, feed.DummyMediaResolver{}, ref.MediaResolver)
+
+	ref = setup("https://cdn.example.org/video/abc")
+	assert.Equal(t, resolver.RedGIFs{Site: "custom"}, ref.MediaResolver)
+	ref = setup("https://example.org/image/abc")
+	assert.Equal(t, feed.DummyMediaResolver{}, ref.MediaResolver)
+
+	// configured clients take precedence and are used for subdomains
+	ref = setup("https://media.example.com/abc.jpg")
+	assert.NotNil(t, ref.GetClient())
+	assert.True(t, ref.GetClient() != custom)
+	ref = setup("https://2ch.hk/b/src/123/456.webm")
+	assert.True(t, ref.GetClient() == custom)
+}
@@ -0,0 +1,199 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/fixture"
+	"github.com/jfk9w/hikkabot/resolver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImgur_ResolveURL(t *testing.T) {
+	server := fixture.Serve(t, "testdata/imgur.json", "https://imgur.com")
+	client := fluhttp.NewClient(nil)
+
+	url, err := new(resolver.Imgur).ResolveURL(context.Background(), client, server.URL+"/gallery/abc", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://i.imgur.com/abc.jpg", url)
+
+	url, err = new(resolver.Imgur).ResolveURL(context.Background(), client, server.URL+"/abc.png", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, server.URL+"/abc.png", url)
+}
+
+func TestImgur_Resolve(t *testing.T) {
+	server := fixture.Serve(t, "testdata/imgur_album.json", "https://api.imgur.com")
+	apiURL := resolver.ImgurAPIURL
+	t.Cleanup(func() { resolver.ImgurAPIURL = apiURL })
+	resolver.ImgurAPIURL = server.URL
+	client := fluhttp.NewClient(nil)
+	imgur := &resolver.Imgur{ClientID: "test"}
+
+	assert.False(t, new(resolver.Imgur).IsAlbum("https://imgur.com/a/xyz"))
+	assert.True(t, imgur.IsAlbum("https://imgur.com/a/xyz"))
+	assert.True(t, imgur.IsAlbum("https://imgur.com/gallery/some-title-abc123"))
+	assert.False(t, imgur.IsAlbum("https://imgur.com/abc.png"))
+
+	resolution, err := imgur.Resolve(context.Background(), client, "https://imgur.com/a/xyz")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]feed.MediaCandidate{
+		{{URL: "https://i.imgur.com/one.jpg", MediaMetadata: feed.MediaMetadata{MIMEType: "image/jpeg", Size: 1000, Width: 640, Height: 480}}},
+		{{URL: "https://i.imgur.com/two.mp4", MediaMetadata: feed.MediaMetadata{MIMEType: "video/mp4", Size: 200000, Width: 320, Height: 240}}},
+	}, resolution.Album)
+
+	resolution, err = imgur.Resolve(context.Background(), client, "https://imgur.com/gallery/some-title-abc123")
+	assert.Nil(t, err)
+	assert.Len(t, resolution.Album, 1)
+	assert.Equal(t, "https://i.imgur.com/three.png", resolution.Album[0][0].URL)
+
+	_, err = imgur.Resolve(context.Background(), client, "https://imgur.com/a/missing")
+	assert.NotNil(t, err)
+}
+
+func TestRedGIFs_ResolveURL(t *testing.T) {
+	server := fixture.Serve(t, "testdata/redgifs.json", "https://api.redgifs.com")
+	template := resolver.RedGIFsURLTemplate
+	t.Cleanup(func() { resolver.RedGIFsURLTemplate = template })
+	// %.0s swallows the site name
+	resolver.RedGIFsURLTemplate = server.URL + "/v1/gfycats/%.0s%s"
+	client := fluhttp.NewClient(nil)
+
+	url, err := resolver.RedGIFs{Site: "redgifs"}.ResolveURL(context.Background(), client, "https://redgifs.com/watch/someclip/", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://thumbs2.redgifs.com/SomeClip.mp4", url)
+
+	_, err = resolver.RedGIFs{Site: "redgifs"}.ResolveURL(context.Background(), client, "https://redgifs.com/watch/missing", 0)
+	assert.NotNil(t, err)
+}
+
+func TestYouTube_ResolveURL(t *testing.T) {
+	server := fixture.Serve(t, "testdata/youtube.json", "http://youtube.com")
+	videoInfoURL := resolver.YouTubeVideoInfoURL
+	t.Cleanup(func() { resolver.YouTubeVideoInfoURL = videoInfoURL })
+	resolver.YouTubeVideoInfoURL = server.URL + "/get_video_info"
+	client := fluhttp.NewClient(nil)
+
+	ref := new(feed.MediaRef)
+	url, err := (&resolver.YouTube{MediaRef: ref}).ResolveURL(context.Background(), client, "https://youtu.be/abc", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://r1.googlevideo.com/videoplayback?itag=22", url)
+	assert.Equal(t, int64(5000), ref.Size)
+	assert.Equal(t, "video/mp4", ref.MIMEType)
+
+	ref = new(feed.MediaRef)
+	url, err = (&resolver.YouTube{MediaRef: ref}).ResolveURL(context.Background(), client, "https://www.youtube.com/watch?v=abc", 2000)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://r1.googlevideo.com/videoplayback?itag=18", url)
+	assert.Equal(t, int64(1000), ref.Size)
+
+	_, err = (&resolver.YouTube{MediaRef: new(feed.MediaRef)}).ResolveURL(context.Background(), client, "https://youtu.be/abc", 500)
+	assert.NotNil(t, err)
+}
+
+func TestFFmpeg_MIMETypes(t *testing.T) {
+	// mp4 is not converted, mp4 which is too large is downscaled instead
+	assert.ElementsMatch(t, []string{"video/webm", "image/gif"}, new(resolver.FFmpeg).MIMETypes())
+}
+
+func TestYtDlp_Resolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hikkabot-ytdlp-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	info, err := filepath.Abs("testdata/ytdlp.json")
+	assert.Nil(t, err)
+	binary := filepath.Join(dir, "yt-dlp")
+	script := fmt.Sprintf("#!/bin/sh\n[ \"$5\" = \"https://youtu.be/abc\" ] || { echo \"ERROR: unsupported url\" >&2; exit 1; }\ncat %s\n", info)
+	assert.Nil(t, ioutil.WriteFile(binary, []byte(script), 0755))
+
+	ytdlp := &resolver.YtDlp{Binary: binary}
+	resolution, err := ytdlp.Resolve(context.Background(), nil, "https://youtu.be/abc")
+	assert.Nil(t, err)
+	candidates := make(map[string]feed.MediaCandidate)
+	for _, candidate := range resolution.Candidates {
+		candidates[candidate.URL] = candidate
+	}
+
+	assert.Len(t, candidates, 5)
+	assert.Equal(t, feed.MediaMetadata{MIMEType: "video/mp4", Size: 5000, Width: 1280, Height: 720, Duration: 42},
+		candidates["https://cdn.example.com/720.mp4"].MediaMetadata)
+	assert.Equal(t, map[string]string{"User-Agent": "Mozilla/5.0", "Referer": "https://youtu.be/abc"},
+		candidates["https://cdn.example.com/720.mp4"].Headers)
+	assert.Contains(t, candidates, "https://cdn.example.com/1080-video.mp4")
+	assert.Contains(t, candidates, "https://cdn.example.com/360.webm")
+
+	// approximate sizes are used for ranking only
+	large := candidates["https://cdn.example.com/2160.mp4"]
+	assert.Equal(t, int64(0), large.Size)
+	assert.Equal(t, int64(104857600), large.EstimatedSize)
+	small := candidates["https://cdn.example.com/360.mp4"]
+	assert.Equal(t, int64(0), small.Size)
+	assert.Equal(t, int64(1000), small.EstimatedSize)
+
+	// mp4 with audio is ranked higher than webm and video-only tracks
+	assert.Greater(t, small.Quality, candidates["https://cdn.example.com/360.webm"].Quality)
+	assert.Greater(t, small.Quality, candidates["https://cdn.example.com/1080-video.mp4"].Quality)
+
+	_, err = ytdlp.Resolve(context.Background(), nil, "https://youtu.be/missing")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unsupported url")
+}
+
+func TestVReddit_Resolve(t *testing.T) {
+	server := fixture.Serve(t, "testdata/vreddit.json", "https://v.redd.it")
+	vredditURL := resolver.VRedditURL
+	t.Cleanup(func() { resolver.VRedditURL = vredditURL })
+	resolver.VRedditURL = server.URL
+	client := fluhttp.NewClient(nil)
+	muxer := &feed.MediaManager{Converters: map[string]feed.MediaConverter{resolver.DASHMIMEType: resolver.DASH{}}}
+
+	resolve := func(manager *feed.MediaManager, url string) ([]feed.MediaCandidate, error) {
+		ref := &feed.MediaRef{Manager: manager}
+		resolution, err := (&resolver.VReddit{MediaRef: ref}).Resolve(context.Background(), client, url)
+		if err != nil {
+			return nil, err
+		}
+
+		return resolution.Candidates, nil
+	}
+
+	// the best video track is a fallback for the playlist
+	candidates, err := resolve(muxer, "https://v.redd.it/abc")
+	assert.Nil(t, err)
+	assert.Equal(t, []feed.MediaCandidate{
+		{
+			URL:           server.URL + "/abc/DASHPlaylist.mpd",
+			MediaMetadata: feed.MediaMetadata{MIMEType: resolver.DASHMIMEType, Width: 1280, Height: 720, Duration: 10},
+			Quality:       1,
+			EstimatedSize: 5160000,
+		},
+		{
+			URL:           server.URL + "/abc/DASH_720.mp4",
+			MediaMetadata: feed.MediaMetadata{Width: 1280, Height: 720, Duration: 10},
+			EstimatedSize: 5000000,
+		},
+	}, candidates)
+
+	// video tracks are used as is if they can not be muxed
+	candidates, err = resolve(new(feed.MediaManager), "https://v.redd.it/abc/DASH_720.mp4?source=fallback")
+	assert.Nil(t, err)
+	assert.Len(t, candidates, 1)
+	assert.Equal(t, server.URL+"/abc/DASH_720.mp4", candidates[0].URL)
+	assert.Equal(t, "", candidates[0].MIMEType)
+
+	// or if there is no audio
+	candidates, err = resolve(muxer, "https://v.redd.it/silent")
+	assert.Nil(t, err)
+	assert.Len(t, candidates, 1)
+	assert.Equal(t, server.URL+"/silent/DASH_720.mp4", candidates[0].URL)
+
+	_, err = resolve(muxer, "https://v.redd.it/missing")
+	assert.NotNil(t, err)
+}
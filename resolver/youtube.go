@@ -70,6 +70,8 @@ func (vi *YouTubeVideoInfo) DecodeFrom(r io.Reader) error {
 	return nil
 }
 
+var YouTubeVideoInfoURL = "http://youtube.com/get_video_info"
+
 type YouTube struct {
 	*feed.MediaRef
 }
@@ -94,7 +96,8 @@ func (r *YouTube) ResolveURL(ctx context.Context, client *fluhttp.Client, urlstr
 
 	info := new(YouTubeVideoInfo)
 	if err := client.
-		GET("http://youtube.com/get_video_info?video_id=" + id).
+		GET(YouTubeVideoInfoURL).
+		QueryParam("video_id", id).
 		Context(ctx).
 		Execute().
 		CheckStatus(http.StatusOK).
@@ -120,7 +123,7 @@ func (r *YouTube) ResolveURL(ctx context.Context, client *fluhttp.Client, urlstr
 		}
 	}
 
-	if maxSize < 0 {
+	if bestSize < 0 {
 		return "", errors.Errorf("failed to find suitable video in: %+v", info.formats)
 	}
 
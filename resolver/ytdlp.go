@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+)
+
+// DefaultYtDlpDomains are video host domains resolved with yt-dlp by default.
+var DefaultYtDlpDomains = []string{
+	"youtube.com",
+	"youtu.be",
+	"vimeo.com",
+	"streamable.com",
+	"twitch.tv",
+	"tiktok.com",
+}
+
+// YtDlpRule creates a rule which resolves URLs of the domains and their subdomains with yt-dlp.
+func YtDlpRule(binary string, domains []string) Rule {
+	if len(domains) == 0 {
+		domains = DefaultYtDlpDomains
+	}
+
+	hosts := make([]string, len(domains))
+	for i, domain := range domains {
+		hosts[i] = "*." + strings.ToLower(domain)
+	}
+
+	return Rule{
+		Name:  "ytdlp",
+		Hosts: hosts,
+		New:   func(ref *feed.MediaRef) feed.MediaResolver { return &YtDlp{Binary: binary, MediaRef: ref} },
+	}
+}
+
+type YtDlpFormat struct {
+	URL            string  `json:"url"`
+	Ext            string  `json:"ext"`
+	Protocol       string  `json:"protocol"`
+	VCodec         string  `json:"vcodec"`
+	ACodec         string  `json:"acodec"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	Filesize       int64   `json:"filesize"`
+	FilesizeApprox float64 `json:"filesize_approx"`
+	// HTTPHeaders are required for downloading the format.
+	HTTPHeaders map[string]string `json:"http_headers"`
+}
+
+// usable checks if the format can be downloaded directly and contains video.
+func (f YtDlpFormat) usable() bool {
+	return f.URL != "" && f.VCodec != "none" && YtDlpMIMETypes[f.Ext] != "" &&
+		(f.Protocol == "" || f.Protocol == "http" || f.Protocol == "https")
+}
+
+// quality ranks formats by presence of audio, container and resolution.
+func (f YtDlpFormat) quality() int {
+	quality := f.Height
+	if f.Ext == "mp4" {
+		quality += 1 << 16
+	}
+
+	if f.ACodec != "none" {
+		quality += 1 << 17
+	}
+
+	return quality
+}
+
+type YtDlpInfo struct {
+	YtDlpFormat
+	Duration float64       `json:"duration"`
+	Formats  []YtDlpFormat `json:"formats"`
+}
+
+// YtDlpMIMETypes are MIME types of supported yt-dlp format extensions.
+var YtDlpMIMETypes = map[string]string{
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+}
+
+// YtDlp resolves video pages with yt-dlp binary.
+// Resolve returns all usable formats as candidates which are ranked by MediaManager.
+type YtDlp struct {
+	Binary string
+	*feed.MediaRef
+}
+
+func (r *YtDlp) GetClient() *fluhttp.Client {
+	return nil
+}
+
+// ResolveURL is not used since YtDlp implements feed.MediaCandidateResolver.
+func (r *YtDlp) ResolveURL(context.Context, *fluhttp.Client, string, int64) (string, error) {
+	return "", errors.New("yt-dlp resolves media candidates only")
+}
+
+func (r *YtDlp) Resolve(ctx context.Context, _ *fluhttp.Client, url string) (*feed.MediaResolution, error) {
+	info, err := r.info(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	formats := info.Formats
+	if len(formats) == 0 {
+		formats = []YtDlpFormat{info.YtDlpFormat}
+	}
+
+	candidates := make([]feed.MediaCandidate, 0, len(formats))
+	for _, format := range formats {
+		if !format.usable() {
+			continue
+		}
+
+		candidates = append(candidates, feed.MediaCandidate{
+			URL: format.URL,
+			MediaMetadata: feed.MediaMetadata{
+				MIMEType: YtDlpMIMETypes[format.Ext],
+				Size:     format.Filesize,
+				Width:    format.Width,
+				Height:   format.Height,
+				Duration: int(info.Duration),
+			},
+			Quality:       format.quality(),
+			EstimatedSize: int64(format.FilesizeApprox),
+			Headers:       format.HTTPHeaders,
+		})
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.Errorf("failed to find suitable format among %d", len(formats))
+	}
+
+	return &feed.MediaResolution{Candidates: candidates}, nil
+}
+
+func (r *YtDlp) info(ctx context.Context, url string) (*YtDlpInfo, error) {
+	cmd := exec.CommandContext(ctx, r.Binary,
+		"--dump-single-json", // write format info to stdout
+		"--no-playlist",
+		"--no-warnings",
+		"--", url)
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "run yt-dlp: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	info := new(YtDlpInfo)
+	if err := json.Unmarshal(stdout.Bytes(), info); err != nil {
+		return nil, errors.Wrap(err, "decode yt-dlp output")
+	}
+
+	return info, nil
+}
@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const DiscordMaxContentSize = 2000
+
+// Discord sends messages to a Discord webhook.
+type Discord struct {
+	Client *http.Client
+	URL    string
+}
+
+func (d *Discord) Send(ctx context.Context, message Message) error {
+	content := Markdown(message.Text)
+	files := make([]Media, 0)
+	for _, media := range message.Media {
+		if media.Data != nil {
+			files = append(files, media)
+		} else {
+			content += "\n" + media.URL
+		}
+	}
+
+	chunks := split(strings.TrimSpace(content), DiscordMaxContentSize)
+	if len(chunks) == 0 {
+		chunks = append(chunks, "")
+	}
+
+	for i, chunk := range chunks {
+		var attachments []Media
+		if i == len(chunks)-1 {
+			attachments = files
+		}
+
+		if err := d.execute(ctx, chunk, attachments); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Discord) execute(ctx context.Context, content string, files []Media) error {
+	payload, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return errors.Wrap(err, "encode payload")
+	}
+
+	body := new(bytes.Buffer)
+	contentType := "application/json"
+	if len(files) == 0 {
+		body.Write(payload)
+	} else {
+		writer := multipart.NewWriter(body)
+		if err := writer.WriteField("payload_json", string(payload)); err != nil {
+			return errors.Wrap(err, "write payload")
+		}
+
+		for i, file := range files {
+			part, err := writer.CreateFormFile(fmt.Sprintf("files[%d]", i), file.Filename)
+			if err != nil {
+				return errors.Wrap(err, "create form file")
+			}
+
+			if _, err := part.Write(file.Data); err != nil {
+				return errors.Wrap(err, "write form file")
+			}
+		}
+
+		if err := writer.Close(); err != nil {
+			return errors.Wrap(err, "close multipart")
+		}
+
+		contentType = writer.FormDataContentType()
+	}
+
+	return post(ctx, d.Client, d.URL, contentType, body)
+}
+
+func post(ctx context.Context, client *http.Client, url, contentType string, body *bytes.Buffer) error {
+	return do(ctx, client, http.MethodPost, url, nil, contentType, body, nil)
+}
+
+func do(ctx context.Context, client *http.Client, method, url string, headers map[string]string,
+	contentType string, body *bytes.Buffer, result interface{}) error {
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s", method, url)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("%s %s: status %s", method, url, resp.Status)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return errors.Wrap(err, "decode response")
+		}
+	}
+
+	return nil
+}
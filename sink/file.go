@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+)
+
+// File appends every message as a JSON line to the file at Path.
+// Uploaded media is written to separate files in the "media" directory next to Path
+// and referenced by their paths relative to the directory of Path.
+type File struct {
+	Path string
+	mu   flu.Mutex
+}
+
+type fileMedia struct {
+	Type     string `json:"type"`
+	URL      string `json:"url,omitempty"`
+	Path     string `json:"path,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+}
+
+type fileRecord struct {
+	FeedID feed.ID     `json:"feed_id"`
+	Text   string      `json:"text,omitempty"`
+	Media  []fileMedia `json:"media,omitempty"`
+}
+
+func (f *File) Send(_ context.Context, message Message) error {
+	defer f.mu.Lock().Unlock()
+	record := fileRecord{FeedID: message.FeedID, Text: message.Text}
+	for _, media := range message.Media {
+		path, err := f.writeMedia(media)
+		if err != nil {
+			return err
+		}
+
+		record.Media = append(record.Media, fileMedia{
+			Type:     media.Type,
+			URL:      media.URL,
+			Path:     path,
+			MIMEType: media.MIMEType,
+		})
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(record); err != nil {
+		return errors.Wrap(err, "write")
+	}
+
+	return nil
+}
+
+// writeMedia writes uploaded media data to a file named by its contents hash
+// and returns its path relative to the directory of Path.
+func (f *File) writeMedia(media Media) (string, error) {
+	if media.Data == nil {
+		return "", nil
+	}
+
+	hash := sha256.Sum256(media.Data)
+	name := hex.EncodeToString(hash[:]) + mediaExtension(media)
+	path := filepath.Join("media", name)
+	fullPath := filepath.Join(filepath.Dir(f.Path), path)
+	if _, err := os.Stat(fullPath); err == nil {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", errors.Wrap(err, "create media directory")
+	}
+
+	if err := ioutil.WriteFile(fullPath, media.Data, 0644); err != nil {
+		return "", errors.Wrap(err, "write media")
+	}
+
+	return path, nil
+}
+
+func mediaExtension(media Media) string {
+	if ext := filepath.Ext(media.Filename); ext != "" {
+		return ext
+	}
+
+	if exts, err := mime.ExtensionsByType(media.MIMEType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+
+	return ""
+}
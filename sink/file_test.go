@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile_Send(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hikkabot-sink-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := &File{Path: filepath.Join(dir, "feed.jsonl")}
+	assert.Nil(t, file.Send(context.Background(), Message{
+		FeedID: 1,
+		Text:   "text",
+		Media: []Media{
+			{Type: "photo", Filename: "image.jpg", MIMEType: "image/jpeg", Data: []byte("image")},
+			{Type: "video", URL: "https://example.com/video.mp4"},
+		},
+	}))
+
+	data, err := ioutil.ReadFile(file.Path)
+	assert.Nil(t, err)
+	record := new(fileRecord)
+	assert.Nil(t, json.Unmarshal(data, record))
+	assert.Equal(t, "text", record.Text)
+	assert.Len(t, record.Media, 2)
+	assert.Equal(t, "", record.Media[1].Path)
+	assert.Equal(t, "https://example.com/video.mp4", record.Media[1].URL)
+
+	media := record.Media[0]
+	assert.Equal(t, "image/jpeg", media.MIMEType)
+	assert.Equal(t, ".jpg", filepath.Ext(media.Path))
+	assert.NotContains(t, string(data), "aW1hZ2U")
+	contents, err := ioutil.ReadFile(filepath.Join(dir, media.Path))
+	assert.Nil(t, err)
+	assert.Equal(t, "image", string(contents))
+}
@@ -0,0 +1,119 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var matrixMessageTypes = map[string]string{
+	"photo":     "m.image",
+	"animation": "m.video",
+	"video":     "m.video",
+	"audio":     "m.audio",
+	"document":  "m.file",
+}
+
+// Matrix sends messages to a Matrix room.
+type Matrix struct {
+	Client *http.Client
+	URL    string
+	Room   string
+	Token  string
+	txnID  int64
+}
+
+func (m *Matrix) Send(ctx context.Context, message Message) error {
+	text := message.Text
+	for _, media := range message.Media {
+		if media.Data == nil {
+			text += "\n" + media.URL
+		}
+	}
+
+	if text = strings.TrimSpace(text); text != "" {
+		if err := m.send(ctx, map[string]interface{}{
+			"msgtype":        "m.text",
+			"body":           PlainText(text),
+			"format":         "org.matrix.custom.html",
+			"formatted_body": strings.ReplaceAll(text, "\n", "<br>"),
+		}); err != nil {
+			return errors.Wrap(err, "send text")
+		}
+	}
+
+	for _, media := range message.Media {
+		if media.Data == nil {
+			continue
+		}
+
+		contentURI, err := m.upload(ctx, media)
+		if err != nil {
+			return errors.Wrap(err, "upload")
+		}
+
+		msgtype, ok := matrixMessageTypes[media.Type]
+		if !ok {
+			msgtype = "m.file"
+		}
+
+		if err := m.send(ctx, map[string]interface{}{
+			"msgtype": msgtype,
+			"body":    media.Filename,
+			"url":     contentURI,
+			"info": map[string]interface{}{
+				"mimetype": media.MIMEType,
+				"size":     len(media.Data),
+			},
+		}); err != nil {
+			return errors.Wrap(err, "send media")
+		}
+	}
+
+	return nil
+}
+
+func (m *Matrix) headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + m.Token}
+}
+
+func (m *Matrix) send(ctx context.Context, content map[string]interface{}) error {
+	body, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "encode content")
+	}
+
+	txnID := fmt.Sprintf("hikkabot%d.%d", time.Now().UnixNano(), atomic.AddInt64(&m.txnID, 1))
+	endpoint := fmt.Sprintf("%s/_matrix/client/r0/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(m.URL, "/"), url.PathEscape(m.Room), txnID)
+	return do(ctx, m.Client, http.MethodPut, endpoint, m.headers(),
+		"application/json", bytes.NewBuffer(body), nil)
+}
+
+func (m *Matrix) upload(ctx context.Context, media Media) (string, error) {
+	resp := new(struct {
+		ContentURI string `json:"content_uri"`
+	})
+
+	mimeType := media.MIMEType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	endpoint := fmt.Sprintf("%s/_matrix/media/r0/upload?filename=%s",
+		strings.TrimRight(m.URL, "/"), url.QueryEscape(media.Filename))
+	if err := do(ctx, m.Client, http.MethodPost, endpoint, m.headers(),
+		mimeType, bytes.NewBuffer(media.Data), resp); err != nil {
+		return "", err
+	}
+
+	return resp.ContentURI, nil
+}
@@ -0,0 +1,87 @@
+package sink
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	fluhttp "github.com/jfk9w-go/flu/http"
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w/hikkabot/botapi"
+)
+
+// NewSender creates a telegram.Sender which does not talk to Telegram,
+// but decodes Bot API requests into Messages and passes them to the sink instead.
+// This way the format.HTMLWriter pagination and media handling are preserved for sinks.
+func NewSender(sink Sink, media string) telegram.Sender {
+	if media == "" {
+		media = MediaUpload
+	}
+
+	handler := &handler{sink: sink, media: media}
+	client := fluhttp.NewClient(&http.Client{Transport: botapi.HandlerTransport{Handler: handler}})
+	return telegram.NewBot(client, "sink")
+}
+
+type handler struct {
+	sink      Sink
+	media     string
+	messageID int64
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := botapi.ParseRequest(r)
+	if err != nil {
+		botapi.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !request.IsSend() {
+		botapi.WriteResult(w, true)
+		return
+	}
+
+	message := Message{
+		FeedID: request.ChatID(),
+		Text:   request.Text(),
+		Media:  h.filterMedia(request.Media()),
+	}
+
+	if message.Text != "" || len(message.Media) > 0 {
+		if err := h.sink.Send(r.Context(), message); err != nil {
+			log.Printf("[sink > %d] %s failed: %s", message.FeedID, request.Method, err)
+			botapi.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if request.Method != "sendMediaGroup" {
+		botapi.WriteResult(w, request.MessageResult(atomic.AddInt64(&h.messageID, 1)))
+		return
+	}
+
+	results := make([]interface{}, len(message.Media))
+	for i := range results {
+		results[i] = request.MessageResult(atomic.AddInt64(&h.messageID, 1))
+	}
+
+	botapi.WriteResult(w, results)
+}
+
+func (h *handler) filterMedia(media []Media) []Media {
+	filtered := make([]Media, 0, len(media))
+	for _, m := range media {
+		switch h.media {
+		case MediaNone:
+			continue
+		case MediaLink:
+			if m.URL == "" {
+				continue
+			}
+		}
+
+		filtered = append(filtered, m)
+	}
+
+	return filtered
+}
@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+
+	telegram "github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/botapi"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/pkg/errors"
+)
+
+type Media = botapi.Media
+
+// Message is what a Sink receives for every message the HTMLWriter would send to Telegram.
+// Text is in Telegram HTML format.
+type Message struct {
+	FeedID feed.ID `json:"feed_id"`
+	Text   string  `json:"text,omitempty"`
+	Media  []Media `json:"media,omitempty"`
+}
+
+type Sink interface {
+	Send(ctx context.Context, message Message) error
+}
+
+const (
+	MediaUpload = "upload"
+	MediaLink   = "link"
+	MediaNone   = "none"
+)
+
+// Config describes a single sink.
+type Config struct {
+
+	// Type is one of "discord", "matrix", "webhook" or "file".
+	Type string
+
+	// URL is the webhook URL for "discord" and "webhook" sinks
+	// and the homeserver URL for "matrix" sink.
+	URL string
+
+	// Room is the Matrix room ID.
+	Room string
+
+	// Token is the Matrix access token.
+	Token string
+
+	// Path is the output file path for "file" sink.
+	// Uploaded media is saved to "media" directory next to it.
+	Path string
+
+	// Media is one of "upload" (default), "link" or "none".
+	// "link" drops uploaded media and keeps only media passed by URL.
+	Media string
+}
+
+func (c Config) New(client *http.Client) (Sink, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	switch c.Type {
+	case "discord":
+		return &Discord{Client: client, URL: c.URL}, nil
+	case "matrix":
+		return &Matrix{Client: client, URL: c.URL, Room: c.Room, Token: c.Token}, nil
+	case "webhook":
+		return &Webhook{Client: client, URL: c.URL}, nil
+	case "file":
+		return &File{Path: c.Path}, nil
+	default:
+		return nil, errors.Errorf("invalid sink type: %s", c.Type)
+	}
+}
+
+// Router is a feed.HTMLWriterFactory which routes feeds to sinks
+// and all the other feeds to the Default factory.
+type Router struct {
+	Default feed.HTMLWriterFactory
+	senders map[feed.ID]telegram.Sender
+}
+
+func (r *Router) Sink(feedID feed.ID, sink Sink, media string) *Router {
+	if r.senders == nil {
+		r.senders = make(map[feed.ID]telegram.Sender)
+	}
+
+	r.senders[feedID] = NewSender(sink, media)
+	return r
+}
+
+func (r *Router) CreateHTMLWriter(ctx context.Context, feedIDs ...feed.ID) (*format.HTMLWriter, error) {
+	sender, err := r.sender(feedIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if sender == nil {
+		return r.Default.CreateHTMLWriter(ctx, feedIDs...)
+	}
+
+	return feed.TelegramHTML{Sender: sender}.CreateHTMLWriter(ctx, feedIDs...)
+}
+
+func (r *Router) CreateTransport(ctx context.Context, feedIDs ...feed.ID) (format.Transport, error) {
+	sender, err := r.sender(feedIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if sender == nil {
+		factory, ok := r.Default.(feed.TransportFactory)
+		if !ok {
+			return nil, errors.New("default factory does not create transports")
+		}
+
+		return factory.CreateTransport(ctx, feedIDs...)
+	}
+
+	return feed.TelegramHTML{Sender: sender}.CreateTransport(ctx, feedIDs...)
+}
+
+// sender returns the sink sender if feedIDs contain a sink feed.
+func (r *Router) sender(feedIDs []feed.ID) (telegram.Sender, error) {
+	var sender telegram.Sender
+	for _, feedID := range feedIDs {
+		if s, ok := r.senders[feedID]; ok {
+			if len(feedIDs) > 1 {
+				return nil, errors.Errorf("sink feed %d can not be written along with other feeds", feedID)
+			}
+
+			sender = s
+		}
+	}
+
+	return sender, nil
+}
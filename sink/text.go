@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var markdownTags = map[string]string{
+	"b":      "**",
+	"strong": "**",
+	"i":      "*",
+	"em":     "*",
+	"u":      "__",
+	"s":      "~~",
+	"strike": "~~",
+	"del":    "~~",
+	"code":   "`",
+	"pre":    "```",
+}
+
+// PlainText strips Telegram HTML markup leaving the text and link URLs.
+func PlainText(text string) string {
+	return convertHTML(text, false)
+}
+
+// Markdown converts Telegram HTML markup to Discord-flavored markdown.
+func Markdown(text string) string {
+	return convertHTML(text, true)
+}
+
+func convertHTML(text string, markdown bool) string {
+	var (
+		out    strings.Builder
+		anchor *strings.Builder
+		href   string
+	)
+
+	tokenizer := html.NewTokenizer(strings.NewReader(text))
+	for tokenizer.Next() != html.ErrorToken {
+		token := tokenizer.Token()
+		switch token.Type {
+		case html.TextToken:
+			if anchor != nil {
+				anchor.WriteString(token.Data)
+			} else {
+				out.WriteString(token.Data)
+			}
+
+		case html.StartTagToken:
+			if token.Data == "a" {
+				href = ""
+				for _, attr := range token.Attr {
+					if attr.Key == "href" {
+						href = attr.Val
+					}
+				}
+
+				anchor = new(strings.Builder)
+			} else if markdown {
+				out.WriteString(markdownTags[token.Data])
+			}
+
+		case html.EndTagToken:
+			if token.Data == "a" && anchor != nil {
+				out.WriteString(formatLink(anchor.String(), href, markdown))
+				anchor = nil
+			} else if markdown {
+				out.WriteString(markdownTags[token.Data])
+			}
+		}
+	}
+
+	if anchor != nil {
+		out.WriteString(formatLink(anchor.String(), href, markdown))
+	}
+
+	return out.String()
+}
+
+func formatLink(text, href string, markdown bool) string {
+	switch {
+	case href == "" || text == href:
+		return text
+	case markdown:
+		return "[" + text + "](" + href + ")"
+	default:
+		return text + " (" + href + ")"
+	}
+}
+
+// split splits the text into chunks no longer than size runes preferring line breaks.
+func split(text string, size int) []string {
+	chunks := make([]string, 0, 1)
+	runes := []rune(text)
+	for len(runes) > size {
+		end := size
+		for i := size - 1; i > size/2; i-- {
+			if runes[i] == '\n' {
+				end = i + 1
+				break
+			}
+		}
+
+		chunks = append(chunks, string(runes[:end]))
+		runes = runes[end:]
+	}
+
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+
+	return chunks
+}
@@ -0,0 +1,20 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdown(t *testing.T) {
+	text := `<b>title</b>` + "\n" + `<a href="https://example.com/1">link</a> &amp; <i>more</i>`
+	assert.Equal(t, "**title**\n[link](https://example.com/1) & *more*", Markdown(text))
+	assert.Equal(t, "title\nlink (https://example.com/1) & more", PlainText(text))
+}
+
+func TestSplit(t *testing.T) {
+	assert.Equal(t, []string{"abc\n", "defg"}, split("abc\ndefg", 5))
+	assert.Equal(t, []string{"ab\nc", "defg"}, split("ab\ncdefg", 4))
+	assert.Equal(t, []string{"abc"}, split("abc", 4))
+	assert.Empty(t, split("", 4))
+}
@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook posts every message as JSON to the URL.
+// Uploaded media data is encoded in base64.
+type Webhook struct {
+	Client *http.Client
+	URL    string
+}
+
+func (w *Webhook) Send(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "encode message")
+	}
+
+	return post(ctx, w.Client, w.URL, "application/json", bytes.NewBuffer(body))
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const FromOptionPrefix = "from="
+
+var fromTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+// From is the starting point of a new subscription.
+// Exactly one of the fields is set.
+type From struct {
+	Now  bool
+	Last int
+	Num  int
+	Time time.Time
+}
+
+var ErrFromUsage = errors.New("from must be one of: now, -N (last N items), post number or date (YYYY-MM-DD)")
+
+func ParseFrom(option string) (*From, error) {
+	value := strings.TrimPrefix(option, FromOptionPrefix)
+	if value == "now" {
+		return &From{Now: true}, nil
+	}
+
+	if strings.HasPrefix(value, "-") {
+		last, err := strconv.Atoi(value[1:])
+		if err != nil || last <= 0 {
+			return nil, ErrFromUsage
+		}
+
+		return &From{Last: last}, nil
+	}
+
+	if num, err := strconv.Atoi(value); err == nil && num > 0 {
+		return &From{Num: num}, nil
+	}
+
+	for _, layout := range fromTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return &From{Time: t}, nil
+		}
+	}
+
+	return nil, ErrFromUsage
+}
+
+// Start returns the index of the first item which should be delivered
+// out of count items sorted from oldest to newest.
+func (f *From) Start(count int, num func(i int) int, date func(i int) time.Time) int {
+	switch {
+	case f == nil:
+		return 0
+	case f.Now:
+		return count
+	case f.Last > 0:
+		if count > f.Last {
+			return count - f.Last
+		}
+
+		return 0
+	}
+
+	for i := 0; i < count; i++ {
+		if (f.Num > 0 && num(i) >= f.Num) || (f.Num == 0 && !date(i).Before(f.Time)) {
+			return i
+		}
+	}
+
+	return count
+}
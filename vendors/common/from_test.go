@@ -0,0 +1,33 @@
+package common_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jfk9w/hikkabot/vendors/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrom_Start(t *testing.T) {
+	nums := []int{10, 20, 30, 40}
+	num := func(i int) int { return nums[i] }
+	date := func(i int) time.Time { return time.Date(2020, 1, nums[i]/10, 0, 0, 0, 0, time.UTC) }
+	for option, start := range map[string]int{
+		"from=now":        4,
+		"from=-1":         3,
+		"from=-10":        0,
+		"from=25":         2,
+		"from=41":         4,
+		"from=2020-01-02": 1,
+		"from=2019-12-31": 0,
+	} {
+		from, err := common.ParseFrom(option)
+		assert.Nil(t, err, option)
+		assert.Equal(t, start, from.Start(len(nums), num, date), option)
+	}
+
+	for _, option := range []string{"from=", "from=-0", "from=yesterday"} {
+		_, err := common.ParseFrom(option)
+		assert.Equal(t, common.ErrFromUsage, err, option)
+	}
+}
@@ -0,0 +1,48 @@
+package common
+
+import (
+	"hash/fnv"
+	"html"
+	"strings"
+)
+
+// TextTokens returns lowercase words of the text with markup and punctuation removed.
+func TextTokens(str string) []string {
+	str = html.UnescapeString(tagRegexp.ReplaceAllString(str, " "))
+	fields := strings.Fields(strings.ToLower(str))
+	tokens := fields[:0]
+	for _, field := range fields {
+		if token := junkRegexp.ReplaceAllString(field, ""); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens
+}
+
+// SimHash computes a 64-bit locality-sensitive fingerprint of the tokens,
+// so that similar texts have hashes with a small Hamming distance.
+func SimHash(tokens []string) uint64 {
+	var weights [64]int
+	for _, token := range tokens {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(token))
+		sum := hash.Sum64()
+		for i := range weights {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var simhash uint64
+	for i, weight := range weights {
+		if weight > 0 {
+			simhash |= 1 << uint(i)
+		}
+	}
+
+	return simhash
+}
@@ -0,0 +1,25 @@
+package common_test
+
+import (
+	"math/bits"
+	"testing"
+
+	"github.com/jfk9w/hikkabot/vendors/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextTokens(t *testing.T) {
+	tokens := common.TextTokens("Hello,<br>WORLD!!! &quot;Привет&quot; <a href=\"#\">мир</a> - ok")
+	assert.Equal(t, []string{"hello", "world", "привет", "мир", "ok"}, tokens)
+}
+
+func TestSimHash(t *testing.T) {
+	text := "the quick brown fox jumps over the lazy dog while the cat watches from the old wooden fence"
+	same := common.SimHash(common.TextTokens(text))
+	assert.Equal(t, same, common.SimHash(common.TextTokens("The quick brown fox, jumps over the lazy dog while the cat watches from the old wooden fence!")))
+
+	similar := common.SimHash(common.TextTokens(text + " again"))
+	other := common.SimHash(common.TextTokens("completely unrelated sentence about programming languages and compilers in general"))
+	assert.True(t, bits.OnesCount64(same^similar) < bits.OnesCount64(same^other))
+	assert.True(t, bits.OnesCount64(same^similar) <= 8)
+}
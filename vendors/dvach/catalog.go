@@ -22,6 +22,7 @@ type CatalogFeedData struct {
 	Query  *common.Query `json:"query"`
 	Offset int           `json:"offset,omitempty"`
 	Auto   []string      `json:"auto,omitempty"`
+	Dedup  bool          `json:"dedup,omitempty"`
 }
 
 type CatalogFeed struct {
@@ -44,12 +45,20 @@ func (f *CatalogFeed) ParseSub(ctx context.Context, ref string, options []string
 	}
 
 	data := CatalogFeedData{Board: groups[4]}
+	var from *common.From
 loop:
 	for i, option := range options {
 		switch {
 		case option == "auto":
 			data.Auto = options[i+1:]
 			break loop
+		case option == "dedup":
+			data.Dedup = true
+		case strings.HasPrefix(option, common.FromOptionPrefix):
+			var err error
+			if from, err = common.ParseFrom(option); err != nil {
+				return feed.SubDraft{}, err
+			}
 		case strings.HasPrefix(option, "re="):
 			option = option[3:]
 			fallthrough
@@ -67,6 +76,23 @@ loop:
 		return feed.SubDraft{}, errors.Wrap(err, "get catalog")
 	}
 
+	if from != nil {
+		threads := make([]Post, 0)
+		for _, post := range catalog.Threads {
+			if data.Query.MatchString(strings.ToLower(post.Comment)) {
+				threads = append(threads, post)
+			}
+		}
+
+		sort.Sort(catalogFeedQueryResult(threads))
+		start := from.Start(len(threads),
+			func(i int) int { return threads[i].Num },
+			func(i int) time.Time { return threads[i].Date })
+		if start > 0 {
+			data.Offset = threads[start-1].Num
+		}
+	}
+
 	draft := feed.SubDraft{
 		ID:   data.Board + "/" + data.Query.String(),
 		Name: catalog.BoardName + " /" + data.Query.String() + "/",
@@ -123,9 +149,25 @@ func (f *CatalogFeed) doLoad(ctx context.Context, rawData feed.Data, queue feed.
 			continue
 		}
 
+		if data.Dedup {
+			if err := f.MediaManager.CheckText(ctx, queue.SubID.FeedID, post.URL(), post.Comment); err != nil {
+				if !errors.Is(err, format.ErrSkipMedia) {
+					return errors.Wrap(err, "check text")
+				}
+
+				log.Printf("[dvach > catalog > /%s /%s/] skipping %s: %s", data.Board, data.Query.String(), post.URL(), err)
+				data.Offset = post.Num
+				if err := queue.Submit(ctx, feed.Update{Data: *data}); err != nil {
+					return nil
+				}
+
+				continue
+			}
+		}
+
 		var media format.MediaRef = nil
 		if len(post.Files) > 0 {
-			media = f.MediaManager.Submit(newMediaRef(f.Client.Client, queue.SubID.FeedID, post.Files[0], false))
+			media = f.MediaManager.Submit(newMediaRef(queue.SubID.FeedID, post.Files[0], false))
 		}
 
 		write := func(html *format.HTMLWriter) error {
@@ -138,7 +180,7 @@ func (f *CatalogFeed) doLoad(ctx context.Context, rawData feed.Data, queue feed.
 			if len(data.Auto) != 0 {
 				button := telegram.Command{Key: "/sub " + post.URL(), Args: data.Auto}.Button("")
 				button[0] = button[2]
-				html.Session.Context = format.WithReplyMarkup(ctx, telegram.InlineKeyboard([]telegram.Button{button}))
+				html.Session.Context = feed.WithReplyMarkup(ctx, telegram.InlineKeyboard([]telegram.Button{button}))
 			}
 
 			html.Bold(post.DateString).Text("\n").
@@ -156,10 +198,18 @@ func (f *CatalogFeed) doLoad(ctx context.Context, rawData feed.Data, queue feed.
 		}
 
 		data.Offset = post.Num
-		if err := queue.Submit(ctx, feed.Update{
+		update := feed.Update{
 			Write: write,
 			Data:  *data,
-		}); err != nil {
+		}
+
+		if data.Dedup {
+			update.Delivered = func(ctx context.Context) error {
+				return f.MediaManager.RecordText(ctx, queue.SubID.FeedID, post.URL(), post.Comment)
+			}
+		}
+
+		if err := queue.Submit(ctx, update); err != nil {
 			return nil
 		}
 	}
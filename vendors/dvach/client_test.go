@@ -0,0 +1,196 @@
+package dvach_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jfk9w-go/telegram-bot-api/format"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/fixture"
+	"github.com/jfk9w/hikkabot/vendors/dvach"
+	"github.com/stretchr/testify/assert"
+)
+
+// noMediaRateLimiter holds media jobs until the manager is closed so that no downloads are made.
+type noMediaRateLimiter struct{}
+
+func (noMediaRateLimiter) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (noMediaRateLimiter) Complete() {}
+
+func newTestClient(t *testing.T, path string) *dvach.Client {
+	server := fixture.Serve(t, path, "https://2ch.hk")
+	host := dvach.Host
+	t.Cleanup(func() { dvach.Host = host })
+	dvach.Host = server.URL
+	return dvach.NewClient(nil, "")
+}
+
+func newTestMediaManager(t *testing.T) *feed.MediaManager {
+	mediaManager := (&feed.MediaManager{RateLimiter: noMediaRateLimiter{}}).Init(context.Background())
+	t.Cleanup(mediaManager.Close)
+	return mediaManager
+}
+
+func load(t *testing.T, vendor feed.Vendor, data interface{}) ([]feed.Update, error) {
+	rawData, err := feed.DataFrom(data)
+	assert.Nil(t, err)
+	queue := feed.NewQueue(feed.SubID{ID: "test", Vendor: "test", FeedID: 1}, 10)
+	go vendor.LoadSub(context.Background(), rawData, queue)
+	updates := make([]feed.Update, 0)
+	for update := range queue.Updates() {
+		if update.Error != nil {
+			return updates, update.Error
+		}
+
+		updates = append(updates, update)
+	}
+
+	return updates, nil
+}
+
+func TestClient_GetCatalog(t *testing.T) {
+	client := newTestClient(t, "testdata/catalog.json")
+	catalog, err := client.GetCatalog(context.Background(), "b")
+	assert.Nil(t, err)
+	assert.Equal(t, "Бред", catalog.BoardName)
+	assert.Len(t, catalog.Threads, 3)
+	post := catalog.Threads[1]
+	assert.Equal(t, 100, post.Num)
+	assert.True(t, post.IsOriginal())
+	assert.Equal(t, "b", post.Board)
+	assert.Equal(t, "2026-10-18 12:40:00 +0300", post.Date.Format("2006-01-02 15:04:05 -0700"))
+	assert.Equal(t, dvach.Host+"/b/res/100.html", post.URL())
+	assert.Equal(t, "image/jpeg", post.Files[0].Type.MIMEType())
+	assert.Equal(t, dvach.Host+"/b/src/100/1.jpg", post.Files[0].URL())
+
+	_, err = client.GetCatalog(context.Background(), "zz")
+	assert.NotNil(t, err)
+}
+
+func TestClient_GetThread(t *testing.T) {
+	client := newTestClient(t, "testdata/thread.json")
+	posts, err := client.GetThread(context.Background(), "b", 100, 0)
+	assert.Nil(t, err)
+	assert.Len(t, posts, 3)
+	assert.Equal(t, 105, posts[1].Num)
+	assert.Equal(t, 100, posts[1].Parent)
+	assert.False(t, posts[1].IsOriginal())
+	assert.Equal(t, dvach.Host+"/b/res/100.html#105", posts[1].URL())
+	assert.Equal(t, 15, *posts[2].Files[0].DurationSecs)
+
+	_, err = client.GetThread(context.Background(), "b", 1, 0)
+	assert.Equal(t, &dvach.Error{Code: -404, Err: "Тред не существует."}, err)
+}
+
+func TestClient_GetPost(t *testing.T) {
+	client := newTestClient(t, "testdata/thread.json")
+	post, err := client.GetPost(context.Background(), "b", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, "Тестовый тред", post.Subject)
+
+	_, err = client.GetPost(context.Background(), "b", 999)
+	assert.Equal(t, dvach.ErrPostNotFound, err)
+}
+
+func TestCatalogFeed_LoadSub(t *testing.T) {
+	vendor := &dvach.CatalogFeed{
+		Client:       newTestClient(t, "testdata/catalog.json"),
+		MediaManager: newTestMediaManager(t),
+	}
+
+	draft, err := vendor.ParseSub(context.Background(), "/b", []string{"привет"})
+	assert.Nil(t, err)
+	assert.Equal(t, "b/привет", draft.ID)
+	assert.Equal(t, "Бред /привет/", draft.Name)
+
+	updates, err := load(t, vendor, draft.Data)
+	assert.Nil(t, err)
+	assert.Len(t, updates, 2)
+	assert.Equal(t, 100, updates[0].Data.(dvach.CatalogFeedData).Offset)
+	assert.Equal(t, 102, updates[1].Data.(dvach.CatalogFeedData).Offset)
+
+	updates, err = load(t, vendor, dvach.CatalogFeedData{Board: "b", Offset: 101})
+	assert.Nil(t, err)
+	assert.Len(t, updates, 1)
+	assert.Equal(t, 102, updates[0].Data.(dvach.CatalogFeedData).Offset)
+
+	_, err = load(t, vendor, dvach.CatalogFeedData{Board: "zz"})
+	assert.NotNil(t, err)
+	for option, offset := range map[string]int{"from=now": 102, "from=-1": 101, "from=101": 100} {
+		draft, err := vendor.ParseSub(context.Background(), "/b", []string{option})
+		assert.Nil(t, err)
+		assert.Equal(t, offset, draft.Data.(dvach.CatalogFeedData).Offset, option)
+	}
+}
+
+// skipTextDedup skips texts of the given URLs.
+type skipTextDedup map[string]bool
+
+func (d skipTextDedup) Check(ctx context.Context, feedID feed.ID, url, mimeType string, blob format.Blob) error {
+	return nil
+}
+
+func (d skipTextDedup) CheckText(ctx context.Context, feedID feed.ID, url, text string) error {
+	if d[url] {
+		return format.ErrSkipMedia
+	}
+
+	return nil
+}
+
+func (d skipTextDedup) RecordText(ctx context.Context, feedID feed.ID, url, text string) error {
+	return nil
+}
+
+func TestCatalogFeed_LoadSubDedup(t *testing.T) {
+	mediaManager := newTestMediaManager(t)
+	vendor := &dvach.CatalogFeed{
+		Client:       newTestClient(t, "testdata/catalog.json"),
+		MediaManager: mediaManager,
+	}
+
+	mediaManager.Dedup = skipTextDedup{dvach.Host + "/b/res/100.html": true}
+
+	// skipped threads advance the offset without being written
+	updates, err := load(t, vendor, dvach.CatalogFeedData{Board: "b", Dedup: true})
+	assert.Nil(t, err)
+	assert.Len(t, updates, 3)
+	assert.Nil(t, updates[0].Write)
+	assert.Equal(t, 100, updates[0].Data.(dvach.CatalogFeedData).Offset)
+	assert.NotNil(t, updates[1].Write)
+	assert.Equal(t, 101, updates[1].Data.(dvach.CatalogFeedData).Offset)
+}
+
+func TestThreadFeed_LoadSub(t *testing.T) {
+	vendor := &dvach.ThreadFeed{
+		Client:       newTestClient(t, "testdata/thread.json"),
+		MediaManager: newTestMediaManager(t),
+	}
+
+	draft, err := vendor.ParseSub(context.Background(), "https://2ch.hk/b/res/100.html", []string{"m"})
+	assert.Nil(t, err)
+	assert.Equal(t, "b/100", draft.ID)
+	assert.Equal(t, "#ТестовыйТред", draft.Name)
+
+	updates, err := load(t, vendor, draft.Data)
+	assert.Nil(t, err)
+	assert.Len(t, updates, 2)
+	assert.Equal(t, 101, updates[0].Data.(dvach.ThreadFeedData).Offset)
+	assert.Equal(t, 107, updates[1].Data.(dvach.ThreadFeedData).Offset)
+
+	updates, err = load(t, vendor, dvach.ThreadFeedData{Board: "b", Num: 100, Offset: 106})
+	assert.Nil(t, err)
+	assert.Len(t, updates, 1)
+
+	_, err = load(t, vendor, dvach.ThreadFeedData{Board: "b", Num: 1})
+	assert.NotNil(t, err)
+	for option, offset := range map[string]int{"from=now": 107, "from=-1": 106} {
+		draft, err := vendor.ParseSub(context.Background(), "https://2ch.hk/b/res/100.html", []string{"m", option})
+		assert.Nil(t, err)
+		assert.Equal(t, offset, draft.Data.(dvach.ThreadFeedData).Offset, option)
+	}
+}
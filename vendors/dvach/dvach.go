@@ -8,7 +8,7 @@ import (
 	"time"
 )
 
-const (
+var (
 	Domain = "2ch.hk"
 	Host   = "https://" + Domain
 )
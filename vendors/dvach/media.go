@@ -1,15 +1,23 @@
 package dvach
 
 import (
-	fluhttp "github.com/jfk9w-go/flu/http"
 	"github.com/jfk9w/hikkabot/feed"
 )
 
-func newMediaRef(client *fluhttp.Client, feedID feed.ID, file File, dedup bool) *feed.MediaRef {
-	return &feed.MediaRef{
-		MediaResolver: feed.DummyMediaResolver{Client: client},
-		URL:           file.URL(),
-		Dedup:         dedup,
-		FeedID:        feedID,
+func newMediaRef(feedID feed.ID, file File, dedup bool) *feed.MediaRef {
+	ref := &feed.MediaRef{
+		URL:    file.URL(),
+		Dedup:  dedup,
+		FeedID: feedID,
 	}
+
+	if file.Width != nil && file.Height != nil {
+		ref.Width, ref.Height = *file.Width, *file.Height
+	}
+
+	if file.DurationSecs != nil {
+		ref.Duration = *file.DurationSecs
+	}
+
+	return ref
 }
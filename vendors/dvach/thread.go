@@ -52,12 +52,18 @@ func (f *ThreadFeed) ParseSub(ctx context.Context, ref string, options []string)
 
 	data := ThreadFeedData{Board: groups[4]}
 	data.Num, _ = strconv.Atoi(groups[5])
+	var from *common.From
 	for _, option := range options {
 		switch {
 		case option == "m":
 			data.MediaOnly = true
 		case strings.HasPrefix(option, "#"):
 			data.Tag = option
+		case strings.HasPrefix(option, common.FromOptionPrefix):
+			var err error
+			if from, err = common.ParseFrom(option); err != nil {
+				return feed.SubDraft{}, err
+			}
 		}
 	}
 
@@ -66,6 +72,29 @@ func (f *ThreadFeed) ParseSub(ctx context.Context, ref string, options []string)
 		return feed.SubDraft{}, errors.Wrap(err, "get post")
 	}
 
+	if from != nil {
+		posts, err := f.getThread(ctx, data.Board, data.Num, 0)
+		if err != nil {
+			return feed.SubDraft{}, errors.Wrap(err, "get thread")
+		}
+
+		candidates := make([]Post, 0, len(posts))
+		for _, post := range posts {
+			if !data.MediaOnly || len(post.Files) > 0 {
+				candidates = append(candidates, post)
+			}
+		}
+
+		start := from.Start(len(candidates),
+			func(i int) int { return candidates[i].Num },
+			func(i int) time.Time { return candidates[i].Date })
+		if start < len(candidates) {
+			data.Offset = candidates[start].Num
+		} else if len(posts) > 0 {
+			data.Offset = posts[len(posts)-1].Num + 1
+		}
+	}
+
 	if data.Tag == "" {
 		data.Tag = common.Hashtag(post.Subject)
 	}
@@ -118,7 +147,7 @@ func (f *ThreadFeed) doLoad(ctx context.Context, rawData feed.Data, queue feed.Q
 		media := make([]format.MediaRef, len(post.Files))
 		for i, file := range post.Files {
 			media[i] = f.MediaManager.Submit(
-				newMediaRef(f.Client.Client, queue.SubID.FeedID, file, data.MediaOnly))
+				newMediaRef(queue.SubID.FeedID, file, data.MediaOnly && !feed.IsPreview(ctx)))
 		}
 
 		write := func(html *format.HTMLWriter) error {
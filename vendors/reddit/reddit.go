@@ -2,10 +2,15 @@ package reddit
 
 import "time"
 
+type RedditVideo struct {
+	FallbackURL string `json:"fallback_url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Duration    int    `json:"duration"`
+}
+
 type Media struct {
-	RedditVideo struct {
-		FallbackURL string `json:"fallback_url"`
-	} `json:"reddit_video"`
+	RedditVideo RedditVideo `json:"reddit_video"`
 }
 
 type MediaContainer struct {
@@ -13,13 +18,12 @@ type MediaContainer struct {
 	SecureMedia Media `json:"secure_media"`
 }
 
-func (mc MediaContainer) FallbackURL() string {
-	url := mc.Media.RedditVideo.FallbackURL
-	if url == "" {
-		url = mc.SecureMedia.RedditVideo.FallbackURL
+func (mc MediaContainer) RedditVideo() RedditVideo {
+	if mc.Media.RedditVideo.FallbackURL != "" {
+		return mc.Media.RedditVideo
 	}
 
-	return url
+	return mc.SecureMedia.RedditVideo
 }
 
 type ThingData struct {
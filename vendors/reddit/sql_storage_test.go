@@ -5,7 +5,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/metrics"
 	"github.com/jfk9w/hikkabot/feed"
 	"github.com/jfk9w/hikkabot/vendors/reddit"
 	"github.com/stretchr/testify/assert"
@@ -22,8 +22,9 @@ func (c *clockMock) Now() time.Time {
 func TestSQLite3_Basic(t *testing.T) {
 	ctx := context.Background()
 	clock := &clockMock{now: parseTime(t, "2020-01-01T05:00:00Z")}
-	store, err := feed.NewSQLStorage(clock, ":memory:")
+	store, err := feed.NewSQLStorage(clock, "sqlite3", ":memory:")
 	assert.Nil(t, err)
+	store.Registry = metrics.DummyRegistry{}
 
 	defer store.Close()
 	rstore, err := (&reddit.SQLStorage{
@@ -34,30 +35,35 @@ func TestSQLite3_Basic(t *testing.T) {
 
 	things := []reddit.ThingData{
 		{
+			ID:        1,
 			Name:      "test1",
 			Created:   parseTime(t, "2020-01-01T00:00:00Z"),
 			Subreddit: "a",
 			Ups:       4,
 		},
 		{
+			ID:        2,
 			Name:      "test2",
 			Created:   parseTime(t, "2020-01-01T01:00:00Z"),
 			Subreddit: "a",
 			Ups:       10,
 		},
 		{
+			ID:        3,
 			Name:      "test3",
 			Created:   parseTime(t, "2020-01-01T02:00:00Z"),
 			Subreddit: "a",
 			Ups:       6,
 		},
 		{
+			ID:        4,
 			Name:      "test4",
 			Created:   parseTime(t, "2020-01-01T03:00:00Z"),
 			Subreddit: "a",
 			Ups:       8,
 		},
 		{
+			ID:        5,
 			Name:      "test5",
 			Created:   parseTime(t, "2020-01-01T04:00:00Z"),
 			Subreddit: "a",
@@ -67,7 +73,7 @@ func TestSQLite3_Basic(t *testing.T) {
 
 	data := &reddit.SubredditFeedData{
 		Subreddit: "a",
-		SentIDs:   make(flu.Uint64Set),
+		SentIDs:   make(reddit.Uint64Set),
 	}
 
 	for _, thing := range things {
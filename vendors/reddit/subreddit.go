@@ -3,19 +3,20 @@ package reddit
 import (
 	"context"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/doug-martin/goqu/v9"
 	fluhttp "github.com/jfk9w-go/flu/http"
 	"github.com/jfk9w-go/flu/metrics"
 	"github.com/jfk9w-go/telegram-bot-api/format"
 	"github.com/jfk9w/hikkabot/feed"
-	"github.com/jfk9w/hikkabot/resolver"
 	"github.com/jfk9w/hikkabot/vendors/common"
 	"github.com/pkg/errors"
 )
@@ -34,6 +35,9 @@ type SubredditFeedData struct {
 	LastCleanSecs int64     `json:"last_clean,omitempty"`
 	MediaOnly     bool      `json:"media_only,omitempty"`
 	IndexUsers    bool      `json:"index_users,omitempty"`
+	TextDedup     bool      `json:"text_dedup,omitempty"`
+	// FromSecs is the creation time of the oldest post which may be delivered, see common.From.
+	FromSecs int64 `json:"from,omitempty"`
 }
 
 func (d SubredditFeedData) Copy() SubredditFeedData {
@@ -51,7 +55,6 @@ type SubredditFeed struct {
 	Store        Store
 	MediaManager *feed.MediaManager
 	Metrics      metrics.Registry
-	Viddit       *common.Viddit
 }
 
 func (f *SubredditFeed) getListing(ctx context.Context, subreddit string, limit int) ([]Thing, error) {
@@ -68,28 +71,29 @@ func (f *SubredditFeed) ParseSub(ctx context.Context, ref string, options []stri
 		return feed.SubDraft{}, feed.ErrWrongVendor
 	}
 
-	subreddit := groups[4]
-	things, err := f.getListing(ctx, subreddit, 1)
-	if err != nil {
-		return feed.SubDraft{}, errors.Wrap(err, "get listing")
-	}
-
-	if len(things) > 0 {
-		subreddit = things[0].Data.Subreddit
-	}
-
 	data := SubredditFeedData{
-		Subreddit: subreddit,
 		Top:       0.3,
 		MediaOnly: true,
 	}
 
+	var from *common.From
 	for _, option := range options {
-		switch option {
-		case "!m":
+		switch {
+		case option == "!m":
 			data.MediaOnly = false
-		case "u":
+		case option == "u":
 			data.IndexUsers = true
+		case option == "dedup":
+			data.TextDedup = true
+		case strings.HasPrefix(option, common.FromOptionPrefix):
+			var err error
+			if from, err = common.ParseFrom(option); err != nil {
+				return feed.SubDraft{}, err
+			}
+
+			if from.Num > 0 {
+				return feed.SubDraft{}, errors.New("post number is not supported, use date or -N")
+			}
 		default:
 			var err error
 			data.Top, err = strconv.ParseFloat(option, 64)
@@ -99,7 +103,41 @@ func (f *SubredditFeed) ParseSub(ctx context.Context, ref string, options []stri
 		}
 	}
 
+	limit := 1
+	if from != nil {
+		limit = 100
+	}
+
+	subreddit := groups[4]
+	things, err := f.getListing(ctx, subreddit, limit)
+	if err != nil {
+		return feed.SubDraft{}, errors.Wrap(err, "get listing")
+	}
+
+	if len(things) > 0 {
+		subreddit = things[0].Data.Subreddit
+	}
+
+	data.Subreddit = subreddit
 	data.SentIDs = make(Uint64Set, int(100*data.Top))
+	if from != nil {
+		sort.Sort(redditThings(things))
+		start := from.Start(len(things), nil,
+			func(i int) time.Time { return things[i].Data.Created })
+		for _, thing := range things[:start] {
+			data.SentIDs.Add(thing.Data.ID)
+		}
+
+		// older posts may get into the listing later, so they are filtered by creation time as well
+		switch {
+		case !from.Time.IsZero():
+			data.FromSecs = from.Time.Unix()
+		case start < len(things):
+			data.FromSecs = things[start].Data.Created.Unix()
+		default:
+			data.FromSecs = time.Now().Unix()
+		}
+	}
 	return feed.SubDraft{
 		ID:   data.Subreddit,
 		Name: f.getSubredditName(data.Subreddit),
@@ -107,57 +145,40 @@ func (f *SubredditFeed) ParseSub(ctx context.Context, ref string, options []stri
 	}, nil
 }
 
-func (f *SubredditFeed) newMediaRef(subID feed.SubID, thing ThingData, mediaOnly bool) format.MediaRef {
+// newMediaRefs returns media of the post. Albums yield several media.
+func (f *SubredditFeed) newMediaRefs(ctx context.Context, subID feed.SubID, thing ThingData, mediaOnly bool) []format.MediaRef {
 	ref := &feed.MediaRef{
 		FeedID: subID.FeedID,
 		URL:    thing.URL,
-		Dedup:  mediaOnly,
+		Dedup:  mediaOnly && !feed.IsPreview(ctx),
 	}
 
 	if thing.Domain == "v.redd.it" {
-		ref.URL = thing.MediaContainer.FallbackURL()
-		if ref.URL == "" {
+		video := thing.MediaContainer.RedditVideo()
+		if video.FallbackURL == "" {
 			for _, mc := range thing.CrosspostParentList {
-				ref.URL = mc.FallbackURL()
-				if ref.URL != "" {
+				video = mc.RedditVideo()
+				if video.FallbackURL != "" {
 					break
 				}
 			}
 		}
 
-		if ref.URL == "" {
-			return common.InvalidMediaRef{
+		if video.FallbackURL == "" {
+			return []format.MediaRef{common.InvalidMediaRef{
 				Error: errors.Errorf("failed to find url for %s", thing.URL),
-			}
+			}}
 		}
-	}
 
-	switch thing.Domain {
-	case "gfycat.com", "www.gfycat.com":
-		ref.Blob = true
-		ref.MediaResolver = resolver.RedGIFs{Site: "gfycat"}
-	case "redgifs.com", "www.redgifs.com":
-		ref.Blob = true
-		ref.MediaResolver = resolver.RedGIFs{Site: "redgifs"}
-	case "imgur.com", "www.imgur.com", "i.imgur.com":
-		if strings.Contains(ref.URL, ".gifv") {
-			ref.URL = strings.Replace(ref.URL, ".gifv", ".mp4", 1)
-			ref.MediaResolver = new(feed.DummyMediaResolver)
-		} else {
-			ref.MediaResolver = new(resolver.Imgur)
+		// the fallback video has no sound, so the video is resolved from its DASH playlist instead
+		if !strings.Contains(ref.URL, "v.redd.it/") {
+			ref.URL = video.FallbackURL
 		}
-	case "youtube.com", "www.youtube.com", "youtu.be":
-		ref.MediaResolver = &resolver.YouTube{MediaRef: ref}
-	case "preview.redd.it":
-		ref.MediaResolver = &feed.DummyMediaResolver{Client: f.Client.Client}
-	case "v.redd.it":
-		ref.URL = thing.PermalinkURL()
-		ref.MediaResolver = resolver.Viddit{Client: f.Viddit}
-	default:
-		ref.MediaResolver = new(feed.DummyMediaResolver)
+
+		ref.Width, ref.Height, ref.Duration = video.Width, video.Height, video.Duration
 	}
 
-	return f.MediaManager.Submit(ref)
+	return f.MediaManager.SubmitAll(ctx, ref)
 }
 
 func (f *SubredditFeed) doLoad(ctx context.Context, rawData feed.Data, queue feed.Queue) error {
@@ -180,11 +201,13 @@ func (f *SubredditFeed) doLoad(ctx context.Context, rawData feed.Data, queue fee
 	percentile := -1
 	for _, thing := range things {
 		thing := thing.Data
-		if err := f.Store.Thing(ctx, &thing); err != nil {
-			return errors.Wrap(err, "save post")
+		if !feed.IsPreview(ctx) {
+			if err := f.Store.Thing(ctx, &thing); err != nil {
+				return errors.Wrap(err, "save post")
+			}
 		}
 
-		if data.SentIDs.Has(thing.ID) {
+		if data.SentIDs.Has(thing.ID) || thing.Created.Unix() < data.FromSecs {
 			continue
 		}
 
@@ -204,24 +227,39 @@ func (f *SubredditFeed) doLoad(ctx context.Context, rawData feed.Data, queue fee
 			continue
 		}
 
+		if thing.IsSelf && data.MediaOnly {
+			continue
+		}
+
+		if data.TextDedup {
+			if err := f.MediaManager.CheckText(ctx, queue.SubID.FeedID, thing.PermalinkURL(), thing.Title); err != nil {
+				if !errors.Is(err, format.ErrSkipMedia) {
+					return errors.Wrap(err, "check text")
+				}
+
+				log.Printf("[sub > %s] skipping %s: %s", queue.SubID, thing.PermalinkURL(), err)
+				data.SentIDs.Add(thing.ID)
+				continue
+			}
+		}
+
 		var write feed.WriteHTML
 		if thing.IsSelf {
-			if data.MediaOnly {
-				continue
-			} else {
-				write = func(html *format.HTMLWriter) error {
-					f.writeHTMLPrefix(html, data.IndexUsers, thing).
-						Bold(thing.Title).Text("\n").
-						MarkupString(thing.SelfTextHTML)
-					return nil
-				}
+			write = func(html *format.HTMLWriter) error {
+				f.writeHTMLPrefix(html, data.IndexUsers, thing).
+					Bold(thing.Title).Text("\n").
+					MarkupString(thing.SelfTextHTML)
+				return nil
 			}
 		} else {
-			media := f.newMediaRef(queue.SubID, thing, data.MediaOnly)
+			media := f.newMediaRefs(ctx, queue.SubID, thing, data.MediaOnly)
 			write = func(html *format.HTMLWriter) error {
-				f.writeHTMLPrefix(html, data.IndexUsers, thing).
-					Text(thing.Title).Text("\n").
-					Media(thing.URL, media, true)
+				html = f.writeHTMLPrefix(html, data.IndexUsers, thing).
+					Text(thing.Title).Text("\n")
+				for _, ref := range media {
+					html.Media(thing.URL, ref, len(media) == 1)
+				}
+
 				return nil
 			}
 		}
@@ -237,10 +275,18 @@ func (f *SubredditFeed) doLoad(ctx context.Context, rawData feed.Data, queue fee
 		//	continue
 		//}
 
-		if err := queue.Submit(ctx, feed.Update{
+		update := feed.Update{
 			Write: write,
 			Data:  data.Copy(),
-		}); err != nil {
+		}
+
+		if data.TextDedup {
+			update.Delivered = func(ctx context.Context) error {
+				return f.MediaManager.RecordText(ctx, queue.SubID.FeedID, thing.PermalinkURL(), thing.Title)
+			}
+		}
+
+		if err := queue.Submit(ctx, update); err != nil {
 			return nil
 		}
 	}
@@ -254,18 +300,14 @@ var TemporaryErrorStatusCodes = map[int]bool{
 }
 
 func IsTemporaryError(err error) bool {
-	for {
-		if err = errors.Unwrap(err); err != nil {
-			if err, ok := err.(fluhttp.StatusCodeError); ok && TemporaryErrorStatusCodes[err.Code] {
-				return true
-			}
-
-			if err, ok := err.(net.Error); ok && err.Temporary() {
-				return true
-			}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err, ok := err.(fluhttp.StatusCodeError); ok && TemporaryErrorStatusCodes[err.Code] {
+			return true
 		}
 
-		break
+		if err, ok := err.(net.Error); ok && err.Temporary() {
+			return true
+		}
 	}
 
 	return false
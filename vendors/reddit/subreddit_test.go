@@ -0,0 +1,149 @@
+package reddit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/flu/metrics"
+	"github.com/jfk9w/hikkabot/feed"
+	"github.com/jfk9w/hikkabot/fixture"
+	"github.com/jfk9w/hikkabot/vendors/reddit"
+	"github.com/stretchr/testify/assert"
+)
+
+type storeMock struct {
+	things     []uint64
+	percentile int
+}
+
+func (s *storeMock) Init(_ context.Context) (reddit.Store, error) {
+	return s, nil
+}
+
+func (s *storeMock) Thing(_ context.Context, thing *reddit.ThingData) error {
+	s.things = append(s.things, thing.ID)
+	return nil
+}
+
+func (s *storeMock) Percentile(_ context.Context, _ string, _ float64) (int, error) {
+	return s.percentile, nil
+}
+
+func (s *storeMock) Clean(_ context.Context, _ *reddit.SubredditFeedData) (int, error) {
+	return 0, nil
+}
+
+// noMediaRateLimiter holds media jobs until the manager is closed so that no downloads are made.
+type noMediaRateLimiter struct{}
+
+func (noMediaRateLimiter) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (noMediaRateLimiter) Complete() {}
+
+func newTestSubredditFeed(t *testing.T) (*reddit.SubredditFeed, *storeMock) {
+	auth := fixture.Serve(t, "testdata/auth.json", "https://www.reddit.com", "username", "password")
+	api := fixture.Serve(t, "testdata/listing.json", "https://oauth.reddit.com")
+	host, authEndpoint, timeout := reddit.Host, reddit.AuthEndpoint, reddit.Timeout
+	t.Cleanup(func() { reddit.Host, reddit.AuthEndpoint, reddit.Timeout = host, authEndpoint, timeout })
+	reddit.Host, reddit.AuthEndpoint, reddit.Timeout = api.URL, auth.URL+"/api/v1/access_token", 0
+
+	mediaManager := (&feed.MediaManager{RateLimiter: noMediaRateLimiter{}}).Init(context.Background())
+	t.Cleanup(mediaManager.Close)
+
+	store := &storeMock{percentile: 10}
+	return &reddit.SubredditFeed{
+		Client:       reddit.NewClient(nil, &reddit.Config{Username: "user", Password: "pass"}, "test"),
+		Store:        store,
+		MediaManager: mediaManager,
+		Metrics:      metrics.DummyRegistry{},
+	}, store
+}
+
+func loadSubreddit(t *testing.T, f *reddit.SubredditFeed, data reddit.SubredditFeedData) []feed.Update {
+	rawData, err := feed.DataFrom(data)
+	assert.Nil(t, err)
+	queue := feed.NewQueue(feed.SubID{ID: data.Subreddit, Vendor: "subreddit", FeedID: 1}, 10)
+	go f.LoadSub(context.Background(), rawData, queue)
+	updates := make([]feed.Update, 0)
+	for update := range queue.Updates() {
+		assert.Nil(t, update.Error)
+		updates = append(updates, update)
+	}
+
+	return updates
+}
+
+func TestClient_GetListing(t *testing.T) {
+	f, _ := newTestSubredditFeed(t)
+	things, err := f.GetListing(context.Background(), "meirl", "hot", 100)
+	assert.Nil(t, err)
+	assert.Len(t, things, 4)
+	assert.Equal(t, uint64(364), things[0].Data.ID)
+	assert.Equal(t, int64(1792310400), things[0].Data.Created.Unix())
+	assert.Equal(t, "https://i.redd.it/a4.jpg", things[0].Data.URL)
+	assert.Equal(t, `<div class="md"><p>text</p></div>`, things[3].Data.SelfTextHTML)
+	assert.True(t, things[3].Data.IsSelf)
+}
+
+func TestSubredditFeed_ParseSub(t *testing.T) {
+	f, _ := newTestSubredditFeed(t)
+	draft, err := f.ParseSub(context.Background(), "/r/MeIRL", []string{"!m", "0.5"})
+	assert.Nil(t, err)
+	assert.Equal(t, "meirl", draft.ID)
+	assert.Equal(t, "#meirl", draft.Name)
+	data := draft.Data.(reddit.SubredditFeedData)
+	assert.False(t, data.MediaOnly)
+	assert.Equal(t, 0.5, data.Top)
+
+	draft, err = f.ParseSub(context.Background(), "/r/meirl", []string{"from=2026-10-18"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC).Unix(), draft.Data.(reddit.SubredditFeedData).FromSecs)
+
+	_, err = f.ParseSub(context.Background(), "/b", nil)
+	assert.Equal(t, feed.ErrWrongVendor, err)
+}
+
+func TestSubredditFeed_LoadSub(t *testing.T) {
+	f, store := newTestSubredditFeed(t)
+	updates := loadSubreddit(t, f, reddit.SubredditFeedData{
+		Subreddit: "meirl",
+		SentIDs:   reddit.Uint64Set{364: true},
+		Top:       0.3,
+		MediaOnly: true,
+	})
+
+	assert.Equal(t, []uint64{361, 362, 363, 364}, store.things)
+	assert.Len(t, updates, 1)
+	data := updates[0].Data.(reddit.SubredditFeedData)
+	assert.Equal(t, reddit.Uint64Set{363: true, 364: true}, data.SentIDs)
+
+	updates = loadSubreddit(t, f, reddit.SubredditFeedData{
+		Subreddit: "meirl",
+		SentIDs:   reddit.Uint64Set{},
+		Top:       0.3,
+	})
+
+	assert.Len(t, updates, 3)
+	assert.Equal(t, reddit.Uint64Set{361: true}, updates[0].Data.(reddit.SubredditFeedData).SentIDs)
+	assert.Equal(t, reddit.Uint64Set{361: true, 363: true, 364: true}, updates[2].Data.(reddit.SubredditFeedData).SentIDs)
+
+	// posts created before from= cutoff are skipped
+	updates = loadSubreddit(t, f, reddit.SubredditFeedData{
+		Subreddit: "meirl",
+		SentIDs:   reddit.Uint64Set{},
+		Top:       0.3,
+		FromSecs:  1792310401,
+	})
+
+	assert.Empty(t, updates)
+}
+
+func TestSubredditFeed_LoadSub_TemporaryError(t *testing.T) {
+	f, _ := newTestSubredditFeed(t)
+	updates := loadSubreddit(t, f, reddit.SubredditFeedData{Subreddit: "down", Top: 0.3})
+	assert.Empty(t, updates)
+}